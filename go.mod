@@ -4,6 +4,12 @@ go 1.15
 
 replace github.com/iaroslavscript/cacheman/lib/config => ./lib/config
 
+replace github.com/iaroslavscript/cacheman/lib/grpcserver => ./lib/grpcserver
+
+replace github.com/iaroslavscript/cacheman/lib/memcache => ./lib/memcache
+
+replace github.com/iaroslavscript/cacheman/lib/resp => ./lib/resp
+
 replace github.com/iaroslavscript/cacheman/lib/sdk => ./lib/sdk // indirect
 
 replace github.com/iaroslavscript/cacheman/lib/server => ./lib/server
@@ -14,10 +20,20 @@ replace github.com/iaroslavscript/cacheman/lib/simplereplication => ./lib/simple
 
 replace github.com/iaroslavscript/cacheman/lib/simplescheduler => ./lib/simplescheduler
 
+replace github.com/iaroslavscript/cacheman/lib/writebehind => ./lib/writebehind
+
+replace github.com/iaroslavscript/cacheman/lib/webhook => ./lib/webhook
+
 require (
 	github.com/iaroslavscript/cacheman/lib/config v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/grpcserver v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/memcache v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/resp v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/sdk v0.0.0-00010101000000-000000000000
 	github.com/iaroslavscript/cacheman/lib/server v0.0.0-00010101000000-000000000000
 	github.com/iaroslavscript/cacheman/lib/simplecache v0.0.0-00010101000000-000000000000
 	github.com/iaroslavscript/cacheman/lib/simplereplication v0.0.0-00010101000000-000000000000
 	github.com/iaroslavscript/cacheman/lib/simplescheduler v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/webhook v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/writebehind v0.0.0-00010101000000-000000000000
 )
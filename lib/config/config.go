@@ -2,20 +2,117 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/url"
+	"regexp"
 	"sync"
 )
 
+// Backends of write-behind
+const (
+	WriteBehindNone = ""
+	WriteBehindFile = "file"
+	WriteBehindSql  = "sql"
+)
+
+// Schedulers of expiration
+const (
+	ShedulerHeap  = "heap"
+	ShedulerWheel = "wheel"
+)
+
+// Events notified to webhooks
+const (
+	WebhookExpire = "expire"
+	WebhookEvict  = "evict"
+	WebhookDelete = "delete"
+	WebhookFlush  = "flush"
+)
+
+// Policies of slow watch subscribers
+const (
+	WatchPolicyDisconnect = "disconnect"
+	WatchPolicyDrop       = "drop"
+)
+
+// Origin is the upstream of keys starting with Prefix. Missing keys are
+// fetched from URL with the key without the prefix appended to it.
+// Expired keys are served stale for StaleWhileRevalidateSec while they are
+// fetched again in background and for StaleIfErrorSec if the origin fails.
+type Origin struct {
+	Prefix                  string `json:"prefix"`
+	URL                     string `json:"url"`
+	StaleWhileRevalidateSec int64  `json:"stale_while_revalidate_sec"`
+	StaleIfErrorSec         int64  `json:"stale_if_error_sec"`
+}
+
+// Jitter extends expiration times of keys of Namespace set through RestAPI
+// by a random duration up to Percent of the duration or up to Ms
+// milliseconds whichever is greater. Empty Namespace matches every key.
+type Jitter struct {
+	Namespace string `json:"namespace"`
+	Percent   int64  `json:"percent"`
+	Ms        int64  `json:"ms"`
+}
+
+// Webhook receives notifications about keys starting with Prefix leaving
+// the cache by Events, all events if Events is empty.
+type Webhook struct {
+	URL    string   `json:"url"`
+	Prefix string   `json:"prefix"`
+	Events []string `json:"events"`
+}
+
 //type config struct { // TODO
 type Config struct {
-	BindAddr                    string `json:"bind_addr"`
-	ExpiresDefaultDurationSec   int64  `json:"expires_default_duration_sec"`
-	ReplicationActiveQuequeSize int64  `json:"replication_active_queque_size"`
-	ReplicationRotateEveryMs    int64  `json:"replication_rotate_every_ms"`
-	ShedulerDelExpiredEverySec  int64  `json:"sheduler_del_expired_every_sec"`
-	ShedulerExpiredQuequeSize   int64  `json:"sheduler_expired_queque_size"`
+	BindAddr                    string    `json:"bind_addr"`
+	RespBindAddr                string    `json:"resp_bind_addr"`
+	MemcacheBindAddr            string    `json:"memcache_bind_addr"`
+	GrpcBindAddr                string    `json:"grpc_bind_addr"`
+	ExpiresDefaultDurationSec   int64     `json:"expires_default_duration_sec"`
+	ExpiresJitter               []Jitter  `json:"expires_jitter"`
+	ReplicationActiveQuequeSize int64     `json:"replication_active_queque_size"`
+	ReplicationRotateEveryMs    int64     `json:"replication_rotate_every_ms"`
+	ShedulerDelExpiredEverySec  int64     `json:"sheduler_del_expired_every_sec"`
+	ShedulerExpiredQuequeSize   int64     `json:"sheduler_expired_queque_size"`
+	ShedulerType                string    `json:"sheduler_type"`
+	ShedulerTickMs              int64     `json:"sheduler_tick_ms"`
+	ShedulerBatchSize           int64     `json:"sheduler_batch_size"`
+	ShedulerMaxExpiredPerTick   int64     `json:"sheduler_max_expired_per_tick"`
+	ActiveExpireEveryMs         int64     `json:"active_expire_every_ms"`
+	ActiveExpireSampleSize      int64     `json:"active_expire_sample_size"`
+	MaxKeyLength                int64     `json:"max_key_length"`
+	MaxValueBytes               int64     `json:"max_value_bytes"`
+	KeyPattern                  string    `json:"key_pattern"`
+	WatchBufferSize             int64     `json:"watch_buffer_size"`
+	WatchSlowPolicy             string    `json:"watch_slow_policy"`
+	MaxWaiters                  int64     `json:"max_waiters"`
+	MaxWaitSec                  int64     `json:"max_wait_sec"`
+	PubsubReplicate             bool      `json:"pubsub_replicate"`
+	Origins                     []Origin  `json:"origins"`
+	OriginTimeoutMs             int64     `json:"origin_timeout_ms"`
+	OriginNegativeTtlSec        int64     `json:"origin_negative_ttl_sec"`
+	FillLockTtlSec              int64     `json:"fill_lock_ttl_sec"`
+	FillWaitMs                  int64     `json:"fill_wait_ms"`
+	WriteBehindBackend          string    `json:"write_behind_backend"`
+	WriteBehindPath             string    `json:"write_behind_path"`
+	WriteBehindSqlDriver        string    `json:"write_behind_sql_driver"`
+	WriteBehindSqlTable         string    `json:"write_behind_sql_table"`
+	WriteBehindBatchSize        int64     `json:"write_behind_batch_size"`
+	WriteBehindFlushMs          int64     `json:"write_behind_flush_ms"`
+	WriteBehindQueueSize        int64     `json:"write_behind_queue_size"`
+	Webhooks                    []Webhook `json:"webhooks"`
+	WebhookBatchSize            int64     `json:"webhook_batch_size"`
+	WebhookFlushMs              int64     `json:"webhook_flush_ms"`
+	WebhookQueueSize            int64     `json:"webhook_queue_size"`
+	WebhookTimeoutMs            int64     `json:"webhook_timeout_ms"`
+	WebhookRetries              int64     `json:"webhook_retries"`
+	WebhookRetryMs              int64     `json:"webhook_retry_ms"`
 }
 
+var sqlNamePattern = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
 var instance *Config
 var once sync.Once
 var m sync.Mutex
@@ -44,26 +141,66 @@ func LoadConfig(filepath string) error {
 }
 
 func Validate() error {
-	// no-op at current version
-	// TODO add validation logic
-	return nil
+
+	m.Lock()
+	defer m.Unlock()
+
+	return validate(GetConfig())
 }
 
 func defaultConfig() *Config {
 
 	return &Config{
 		BindAddr:                    "0.0.0.0:8080",
+		RespBindAddr:                "",
+		MemcacheBindAddr:            "",
+		GrpcBindAddr:                "",
 		ExpiresDefaultDurationSec:   30 * 60,
+		ExpiresJitter:               nil,
 		ReplicationActiveQuequeSize: 50000,
 		ReplicationRotateEveryMs:    1000,
 		ShedulerDelExpiredEverySec:  60,
 		ShedulerExpiredQuequeSize:   1000,
+		ShedulerType:                ShedulerHeap,
+		ShedulerTickMs:              10,
+		ShedulerBatchSize:           100,
+		ShedulerMaxExpiredPerTick:   0,
+		ActiveExpireEveryMs:         100,
+		ActiveExpireSampleSize:      20,
+		MaxKeyLength:                250,
+		MaxValueBytes:               1024 * 1024,
+		KeyPattern:                  "^[^[:cntrl:][:space:]]+$",
+		WatchBufferSize:             1024,
+		WatchSlowPolicy:             WatchPolicyDisconnect,
+		MaxWaiters:                  10000,
+		MaxWaitSec:                  60,
+		PubsubReplicate:             false,
+		Origins:                     nil,
+		OriginTimeoutMs:             5000,
+		OriginNegativeTtlSec:        5,
+		FillLockTtlSec:              10,
+		FillWaitMs:                  1000,
+		WriteBehindBackend:          WriteBehindNone,
+		WriteBehindPath:             "",
+		WriteBehindSqlDriver:        "",
+		WriteBehindSqlTable:         "cacheman",
+		WriteBehindBatchSize:        1000,
+		WriteBehindFlushMs:          1000,
+		WriteBehindQueueSize:        50000,
+		Webhooks:                    nil,
+		WebhookBatchSize:            100,
+		WebhookFlushMs:              1000,
+		WebhookQueueSize:            10000,
+		WebhookTimeoutMs:            5000,
+		WebhookRetries:              3,
+		WebhookRetryMs:              500,
 	}
 }
 
 func loadConfig(filepath string) (Config, error) {
 
-	var cfg Config
+	// options absent in the file keep their default values
+	cfg := *defaultConfig()
 	var data []byte
 	var err error
 
@@ -78,3 +215,149 @@ func loadConfig(filepath string) (Config, error) {
 
 	return cfg, err
 }
+
+func validate(cfg *Config) error {
+
+	if cfg.BindAddr == "" {
+		return fmt.Errorf("bind_addr should not be empty")
+	}
+
+	positive := []struct {
+		name  string
+		value int64
+	}{
+		{"expires_default_duration_sec", cfg.ExpiresDefaultDurationSec},
+		{"replication_active_queque_size", cfg.ReplicationActiveQuequeSize},
+		{"replication_rotate_every_ms", cfg.ReplicationRotateEveryMs},
+		{"sheduler_del_expired_every_sec", cfg.ShedulerDelExpiredEverySec},
+		{"sheduler_expired_queque_size", cfg.ShedulerExpiredQuequeSize},
+		{"sheduler_tick_ms", cfg.ShedulerTickMs},
+		{"sheduler_batch_size", cfg.ShedulerBatchSize},
+		{"active_expire_sample_size", cfg.ActiveExpireSampleSize},
+		{"max_key_length", cfg.MaxKeyLength},
+		{"max_value_bytes", cfg.MaxValueBytes},
+		{"watch_buffer_size", cfg.WatchBufferSize},
+		{"max_waiters", cfg.MaxWaiters},
+		{"max_wait_sec", cfg.MaxWaitSec},
+		{"origin_timeout_ms", cfg.OriginTimeoutMs},
+		{"origin_negative_ttl_sec", cfg.OriginNegativeTtlSec},
+		{"fill_lock_ttl_sec", cfg.FillLockTtlSec},
+		{"fill_wait_ms", cfg.FillWaitMs},
+		{"write_behind_batch_size", cfg.WriteBehindBatchSize},
+		{"write_behind_flush_ms", cfg.WriteBehindFlushMs},
+		{"write_behind_queue_size", cfg.WriteBehindQueueSize},
+		{"webhook_batch_size", cfg.WebhookBatchSize},
+		{"webhook_flush_ms", cfg.WebhookFlushMs},
+		{"webhook_queue_size", cfg.WebhookQueueSize},
+		{"webhook_timeout_ms", cfg.WebhookTimeoutMs},
+		{"webhook_retry_ms", cfg.WebhookRetryMs},
+	}
+
+	for _, x := range positive {
+		if x.value < 1 {
+			return fmt.Errorf("%s should be greater than 0, got %d", x.name, x.value)
+		}
+	}
+
+	if cfg.ActiveExpireEveryMs < 0 {
+		return fmt.Errorf("active_expire_every_ms should not be negative, got %d", cfg.ActiveExpireEveryMs)
+	}
+
+	if cfg.WebhookRetries < 0 {
+		return fmt.Errorf("webhook_retries should not be negative, got %d", cfg.WebhookRetries)
+	}
+
+	if cfg.ShedulerMaxExpiredPerTick < 0 {
+		return fmt.Errorf("sheduler_max_expired_per_tick should not be negative, got %d", cfg.ShedulerMaxExpiredPerTick)
+	}
+
+	for _, x := range cfg.ExpiresJitter {
+		if x.Percent < 0 || x.Percent > 100 || x.Ms < 0 {
+			return fmt.Errorf("jitter of namespace '%s' should have percent between 0 and 100 and not negative ms",
+				x.Namespace,
+			)
+		}
+	}
+
+	if _, err := regexp.Compile(cfg.KeyPattern); err != nil {
+		return fmt.Errorf("key_pattern is not a valid regular expression: %s", err.Error())
+	}
+
+	if cfg.WatchSlowPolicy != WatchPolicyDisconnect && cfg.WatchSlowPolicy != WatchPolicyDrop {
+		return fmt.Errorf("watch_slow_policy should be '%s' or '%s', got '%s'",
+			WatchPolicyDisconnect,
+			WatchPolicyDrop,
+			cfg.WatchSlowPolicy,
+		)
+	}
+
+	if cfg.ShedulerType != ShedulerHeap && cfg.ShedulerType != ShedulerWheel {
+		return fmt.Errorf("sheduler_type should be '%s' or '%s', got '%s'",
+			ShedulerHeap,
+			ShedulerWheel,
+			cfg.ShedulerType,
+		)
+	}
+
+	switch cfg.WriteBehindBackend {
+	case WriteBehindNone:
+	case WriteBehindFile, WriteBehindSql:
+		if cfg.WriteBehindPath == "" {
+			return fmt.Errorf("write_behind_path should not be empty")
+		}
+
+		if cfg.WriteBehindBackend == WriteBehindSql && cfg.WriteBehindSqlDriver == "" {
+			return fmt.Errorf("write_behind_sql_driver should not be empty")
+		}
+	default:
+		return fmt.Errorf("write_behind_backend should be '%s', '%s' or empty, got '%s'",
+			WriteBehindFile,
+			WriteBehindSql,
+			cfg.WriteBehindBackend,
+		)
+	}
+
+	if !sqlNamePattern.MatchString(cfg.WriteBehindSqlTable) {
+		return fmt.Errorf("write_behind_sql_table should be a plain SQL name, got '%s'", cfg.WriteBehindSqlTable)
+	}
+
+	for _, x := range cfg.Origins {
+		if x.Prefix == "" {
+			return fmt.Errorf("prefix of origin '%s' should not be empty", x.URL)
+		}
+
+		u, err := url.Parse(x.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url of origin '%s' should be an absolute http or https URL, got '%s'",
+				x.Prefix,
+				x.URL,
+			)
+		}
+
+		if x.StaleWhileRevalidateSec < 0 || x.StaleIfErrorSec < 0 {
+			return fmt.Errorf("stale times of origin '%s' should not be negative", x.Prefix)
+		}
+	}
+
+	for _, x := range cfg.Webhooks {
+		u, err := url.Parse(x.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url of webhook should be an absolute http or https URL, got '%s'", x.URL)
+		}
+
+		for _, event := range x.Events {
+			if event != WebhookExpire && event != WebhookEvict && event != WebhookDelete && event != WebhookFlush {
+				return fmt.Errorf("events of webhook '%s' should be '%s', '%s', '%s' or '%s', got '%s'",
+					x.URL,
+					WebhookExpire,
+					WebhookEvict,
+					WebhookDelete,
+					WebhookFlush,
+					event,
+				)
+			}
+		}
+	}
+
+	return nil
+}
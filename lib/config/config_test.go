@@ -0,0 +1,67 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDefault(t *testing.T) {
+
+	if err := validate(defaultConfig()); err != nil {
+		t.Errorf("validate() of the default config error %s", err.Error())
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	table := []struct {
+		name   string
+		modify func(cfg *Config)
+		err    string
+	}{
+		{"empty bind_addr", func(cfg *Config) { cfg.BindAddr = "" }, "bind_addr"},
+		{"zero expires_default_duration_sec", func(cfg *Config) { cfg.ExpiresDefaultDurationSec = 0 }, "expires_default_duration_sec"},
+		{"negative max_value_bytes", func(cfg *Config) { cfg.MaxValueBytes = -1 }, "max_value_bytes"},
+		{"zero max_key_length", func(cfg *Config) { cfg.MaxKeyLength = 0 }, "max_key_length"},
+		{"zero sheduler_tick_ms", func(cfg *Config) { cfg.ShedulerTickMs = 0 }, "sheduler_tick_ms"},
+		{"zero write_behind_queue_size", func(cfg *Config) { cfg.WriteBehindQueueSize = 0 }, "write_behind_queue_size"},
+		{"zero webhook_batch_size", func(cfg *Config) { cfg.WebhookBatchSize = 0 }, "webhook_batch_size"},
+		{"negative active_expire_every_ms", func(cfg *Config) { cfg.ActiveExpireEveryMs = -1 }, "active_expire_every_ms"},
+		{"negative webhook_retries", func(cfg *Config) { cfg.WebhookRetries = -1 }, "webhook_retries"},
+		{"negative sheduler_max_expired_per_tick", func(cfg *Config) { cfg.ShedulerMaxExpiredPerTick = -1 }, "sheduler_max_expired_per_tick"},
+		{"jitter over 100 percent", func(cfg *Config) { cfg.ExpiresJitter = []Jitter{{Namespace: "a", Percent: 101}} }, "jitter"},
+		{"negative jitter ms", func(cfg *Config) { cfg.ExpiresJitter = []Jitter{{Namespace: "a", Ms: -1}} }, "jitter"},
+		{"invalid key_pattern", func(cfg *Config) { cfg.KeyPattern = "[" }, "key_pattern"},
+		{"unknown watch_slow_policy", func(cfg *Config) { cfg.WatchSlowPolicy = "block" }, "watch_slow_policy"},
+		{"unknown sheduler_type", func(cfg *Config) { cfg.ShedulerType = "list" }, "sheduler_type"},
+		{"unknown write_behind_backend", func(cfg *Config) { cfg.WriteBehindBackend = "redis" }, "write_behind_backend"},
+		{"empty write_behind_path", func(cfg *Config) { cfg.WriteBehindBackend = WriteBehindFile }, "write_behind_path"},
+		{"empty write_behind_sql_driver", func(cfg *Config) {
+			cfg.WriteBehindBackend = WriteBehindSql
+			cfg.WriteBehindPath = "db"
+		}, "write_behind_sql_driver"},
+		{"invalid write_behind_sql_table", func(cfg *Config) { cfg.WriteBehindSqlTable = "a; DROP TABLE b" }, "write_behind_sql_table"},
+		{"empty origin prefix", func(cfg *Config) { cfg.Origins = []Origin{{URL: "http://a/"}} }, "prefix of origin"},
+		{"relative origin url", func(cfg *Config) { cfg.Origins = []Origin{{Prefix: "a/", URL: "/a"}} }, "url of origin"},
+		{"origin url without host", func(cfg *Config) { cfg.Origins = []Origin{{Prefix: "a/", URL: "http://"}} }, "url of origin"},
+		{"negative origin stale time", func(cfg *Config) {
+			cfg.Origins = []Origin{{Prefix: "a/", URL: "http://localhost/", StaleIfErrorSec: -1}}
+		}, "stale times"},
+		{"ftp webhook url", func(cfg *Config) { cfg.Webhooks = []Webhook{{URL: "ftp://localhost/"}} }, "url of webhook"},
+		{"unknown webhook event", func(cfg *Config) {
+			cfg.Webhooks = []Webhook{{URL: "http://localhost/", Events: []string{"set"}}}
+		}, "events of webhook"},
+	}
+
+	for _, x := range table {
+		cfg := defaultConfig()
+		x.modify(cfg)
+
+		err := validate(cfg)
+		if err == nil {
+			t.Errorf("validate() of %s error is nil", x.name)
+		} else if !strings.Contains(err.Error(), x.err) {
+			t.Errorf("validate() of %s error %q; wants it to mention %q", x.name, err.Error(), x.err)
+		}
+	}
+}
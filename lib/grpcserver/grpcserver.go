@@ -0,0 +1,283 @@
+package grpcserver
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/grpcserver/pb"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+	"github.com/iaroslavscript/cacheman/lib/server"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const metricsSubsystem = "grpc"
+
+// GrpcServer serves the gRPC API on top of the same operations
+// as the HTTP server
+type GrpcServer struct {
+	pb.UnimplementedCacheServer
+
+	cfg  *config.Config
+	grpc *grpc.Server
+	srv  *server.Server
+
+	opsApiRequestsTotal prometheus.Counter
+}
+
+var setModes = map[pb.SetMode]int8{
+	pb.SetMode_SET_MODE_ALWAYS:    server.SetAlways,
+	pb.SetMode_SET_MODE_IF_ABSENT: server.SetIfAbsent,
+	pb.SetMode_SET_MODE_IF_EXISTS: server.SetIfExists,
+}
+
+var eventTypes = map[int8]pb.WatchEvent_Type{
+	sdk.ReplActionInsert:       pb.WatchEvent_TYPE_SET,
+	sdk.ReplActionDelete:       pb.WatchEvent_TYPE_DELETE,
+	sdk.ReplActionFlush:        pb.WatchEvent_TYPE_FLUSH,
+	sdk.ReplActionExpire:       pb.WatchEvent_TYPE_EXPIRE,
+	sdk.ReplActionHashSet:      pb.WatchEvent_TYPE_HASH_SET,
+	sdk.ReplActionHashDelete:   pb.WatchEvent_TYPE_HASH_DELETE,
+	sdk.ReplActionListPushHead: pb.WatchEvent_TYPE_LIST_PUSH_HEAD,
+	sdk.ReplActionListPushTail: pb.WatchEvent_TYPE_LIST_PUSH_TAIL,
+	sdk.ReplActionListPopHead:  pb.WatchEvent_TYPE_LIST_POP_HEAD,
+	sdk.ReplActionListPopTail:  pb.WatchEvent_TYPE_LIST_POP_TAIL,
+	sdk.ReplActionListTrim:     pb.WatchEvent_TYPE_LIST_TRIM,
+	sdk.ReplActionZsetAdd:      pb.WatchEvent_TYPE_ZSET_ADD,
+	sdk.ReplActionZsetRemove:   pb.WatchEvent_TYPE_ZSET_REMOVE,
+}
+
+func NewGrpcServer(cfg *config.Config, srv *server.Server) *GrpcServer {
+
+	s := &GrpcServer{
+		cfg: cfg,
+		srv: srv,
+
+		opsApiRequestsTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "api_requests_total",
+			Help:      "The total number of gRPC calls",
+		}),
+	}
+
+	s.grpc = grpc.NewServer(
+		grpc.UnaryInterceptor(s.countUnary),
+		grpc.StreamInterceptor(s.countStream),
+	)
+	pb.RegisterCacheServer(s.grpc, s)
+
+	s.opsApiRequestsTotal.Add(0.0)
+
+	return s
+}
+
+// Serve accepts connections until Close is called
+func (s *GrpcServer) Serve() error {
+
+	l, err := net.Listen("tcp", s.cfg.GrpcBindAddr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("grpc server start listenning at %s", s.cfg.GrpcBindAddr)
+	return s.grpc.Serve(l)
+}
+
+func (s *GrpcServer) Close() {
+	s.grpc.Stop()
+}
+
+func (s *GrpcServer) countUnary(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	s.opsApiRequestsTotal.Inc()
+	return handler(ctx, req)
+}
+
+func (s *GrpcServer) countStream(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	s.opsApiRequestsTotal.Inc()
+	return handler(srv, ss)
+}
+
+func contextError(err error) error {
+
+	if err == context.DeadlineExceeded {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	return status.Error(codes.Canceled, err.Error())
+}
+
+func (s *GrpcServer) get(key string) *pb.GetResponse {
+
+	rec, ok := s.srv.Get(key)
+	if !ok || !rec.IsPlain() {
+		return &pb.GetResponse{}
+	}
+
+	return &pb.GetResponse{
+		Found:     true,
+		Value:     rec.Value,
+		Expires:   rec.Expires / 1000,
+		ExpiresMs: rec.Expires,
+	}
+}
+
+// expiresInMs returns the expiration time of the request in milliseconds
+func expiresInMs(sec, ms int64) (int64, error) {
+
+	if ms == 0 {
+		if sec > server.MaxExpiresInMs/1000 {
+			return 0, status.Error(codes.InvalidArgument, server.ErrExpiresTooLarge.Error())
+		}
+
+		ms = sec * 1000
+		if sec < 0 {
+			ms = -1
+		}
+	}
+
+	return ms, nil
+}
+
+func (s *GrpcServer) set(req *pb.SetRequest) (bool, error) {
+
+	mode, ok := setModes[req.Mode]
+	if !ok {
+		return false, status.Errorf(codes.InvalidArgument, "unknown mode %d", req.Mode)
+	}
+
+	ms, err := expiresInMs(req.ExpiresInSec, req.ExpiresInMs)
+	if err != nil {
+		return false, err
+	}
+
+	stored, err := s.srv.Set(req.Key, req.Value, ms, mode)
+	if err != nil {
+		return false, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return stored, nil
+}
+
+func (s *GrpcServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	return s.get(req.Key), nil
+}
+
+func (s *GrpcServer) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse, error) {
+
+	stored, err := s.set(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.SetResponse{Stored: stored}, nil
+}
+
+func (s *GrpcServer) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	return &pb.DeleteResponse{Found: s.srv.Delete(req.Key)}, nil
+}
+
+func (s *GrpcServer) Exists(ctx context.Context, req *pb.ExistsRequest) (*pb.ExistsResponse, error) {
+	return &pb.ExistsResponse{Found: s.srv.Exists(req.Key)}, nil
+}
+
+func (s *GrpcServer) Touch(ctx context.Context, req *pb.TouchRequest) (*pb.TouchResponse, error) {
+
+	ms, err := expiresInMs(req.ExpiresInSec, req.ExpiresInMs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.TouchResponse{Found: s.srv.Expire(req.Key, ms)}, nil
+}
+
+func (s *GrpcServer) BatchGet(ctx context.Context, req *pb.BatchGetRequest) (*pb.BatchGetResponse, error) {
+
+	resp := &pb.BatchGetResponse{
+		Items: make([]*pb.GetResponse, len(req.Keys)),
+	}
+
+	for i, key := range req.Keys {
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+
+		resp.Items[i] = s.get(key)
+	}
+
+	return resp, nil
+}
+
+func (s *GrpcServer) BatchSet(ctx context.Context, req *pb.BatchSetRequest) (*pb.BatchSetResponse, error) {
+
+	resp := &pb.BatchSetResponse{
+		Items: make([]*pb.BatchSetResult, len(req.Items)),
+	}
+
+	for i, item := range req.Items {
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+
+		result := &pb.BatchSetResult{}
+		if stored, err := s.set(item); err != nil {
+			result.Error = status.Convert(err).Message()
+		} else {
+			result.Stored = stored
+		}
+
+		resp.Items[i] = result
+	}
+
+	return resp, nil
+}
+
+func (s *GrpcServer) Watch(req *pb.WatchRequest, stream pb.Cache_WatchServer) error {
+
+	sub, err := s.srv.Subscribe(req.Prefix)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case item, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher is too slow")
+			}
+
+			event := &pb.WatchEvent{
+				Type:  eventTypes[item.Action],
+				Key:   item.Key.Key,
+				Field: item.Field,
+				Start: item.Start,
+				Stop:  item.Stop,
+				Score: item.Score,
+			}
+
+			if item.HasValue() {
+				event.Value = item.Value.Value
+				event.Expires = item.Value.Expires / 1000
+				event.ExpiresMs = item.Value.Expires
+			}
+
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+
+		case <-stream.Context().Done():
+			return contextError(stream.Context().Err())
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/grpcserver/pb"
+	"github.com/iaroslavscript/cacheman/lib/server"
+	"github.com/iaroslavscript/cacheman/lib/simplecache"
+	"github.com/iaroslavscript/cacheman/lib/simplereplication"
+	"github.com/iaroslavscript/cacheman/lib/simplescheduler"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var testListener *bufconn.Listener
+var testServer *GrpcServer
+var testServerOnce sync.Once
+
+// newTestServer returns the server shared by tests because metrics
+// can be registered only once. The server listens on testListener.
+func newTestServer() *GrpcServer {
+
+	testServerOnce.Do(func() {
+		cfg := *config.GetConfig()
+		srv := server.NewServer(&cfg,
+			simplecache.NewSimpleCache(),
+			simplereplication.NewSimpleReplication(&cfg),
+			simplescheduler.NewSimpleExpirer(&cfg),
+		)
+
+		testListener = bufconn.Listen(1024 * 1024)
+		testServer = NewGrpcServer(&cfg, srv)
+		go testServer.grpc.Serve(testListener)
+	})
+
+	return testServer
+}
+
+// newTestClient returns the client of the test server. Keys of previous
+// tests are flushed.
+func newTestClient(t *testing.T) (pb.CacheClient, *server.Server) {
+
+	s := newTestServer()
+	s.srv.Flush("")
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return testListener.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("grpc.Dial() error %s", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewCacheClient(conn), s.srv
+}
+
+func TestSetGetWatch(t *testing.T) {
+
+	client, srv := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Subscriptions of previous tests end asynchronously
+	for srv.Subscribers() != 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("subscriptions of previous tests are not ended")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	stream, err := client.Watch(ctx, &pb.WatchRequest{Prefix: "a/"})
+	if err != nil {
+		t.Fatalf("Watch() error %s", err.Error())
+	}
+
+	// Wait until the subscription is registered by the server
+	for srv.Subscribers() == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Watch() subscription is not registered")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	batch, err := client.BatchSet(ctx, &pb.BatchSetRequest{
+		Items: []*pb.SetRequest{
+			{Key: "a/1", Value: []byte("x")},
+			{Key: "b/1", Value: []byte("y")},
+			{Key: "a/1", Value: []byte("z"), Mode: pb.SetMode_SET_MODE_IF_ABSENT},
+			{Key: "_reserved", Value: []byte("z")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BatchSet() error %s", err.Error())
+	}
+
+	stored := []bool{true, true, false, false}
+	for i, x := range batch.Items {
+		if x.Stored != stored[i] {
+			t.Errorf("BatchSet() item %d stored = %v; wants %v", i, x.Stored, stored[i])
+		}
+	}
+
+	if batch.Items[3].Error == "" {
+		t.Errorf("BatchSet() wants error for reserved key")
+	}
+
+	get, err := client.Get(ctx, &pb.GetRequest{Key: "a/1"})
+	if err != nil || !get.Found || string(get.Value) != "x" {
+		t.Errorf("Get() = %v, %v; wants value x", get, err)
+	}
+
+	if get.Expires != get.ExpiresMs/1000 {
+		t.Errorf("Get() expires = %d, expires_ms = %d; wants the same time", get.Expires, get.ExpiresMs)
+	}
+
+	if _, err = client.Delete(ctx, &pb.DeleteRequest{Key: "a/1"}); err != nil {
+		t.Fatalf("Delete() error %s", err.Error())
+	}
+
+	events := []pb.WatchEvent_Type{pb.WatchEvent_TYPE_SET, pb.WatchEvent_TYPE_DELETE}
+	for _, x := range events {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv() error %s", err.Error())
+		}
+
+		if event.Type != x || event.Key != "a/1" {
+			t.Errorf("Recv() = %v %s; wants %v a/1", event.Type, event.Key, x)
+		}
+	}
+}
@@ -0,0 +1,619 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+	"github.com/iaroslavscript/cacheman/lib/server"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsSubsystem = "memcache"
+
+// The version reported by the version and stats commands
+const version = "1.6.0-cacheman"
+
+// Expiration times greater than relativeExpiresLimit are unix timestamps
+const relativeExpiresLimit = 60 * 60 * 24 * 30
+
+const maxLineBytes = 2048
+
+var errTooLongLine = errors.New("line is too long")
+
+// MemcacheServer serves memcached ASCII protocol on top of the same
+// operations as the HTTP server. Memcached flags are stored in sdk.Record
+// and cas unique values are record ids.
+type MemcacheServer struct {
+	// counters reported by stats command, they go first to be 64-bit
+	// aligned for atomic operations
+	cmdGet           uint64
+	cmdSet           uint64
+	getHits          uint64
+	getMisses        uint64
+	totalConnections uint64
+
+	cfg      *config.Config
+	conns    map[net.Conn]bool
+	listener net.Listener
+	m        sync.Mutex
+	srv      *server.Server
+	started  time.Time
+
+	opsApiRequestsTotal prometheus.Counter
+	opsConnections      prometheus.Gauge
+}
+
+type conn struct {
+	owner *MemcacheServer
+	r     *bufio.Reader
+	w     *bufio.Writer
+}
+
+type command func(c *conn, args [][]byte) bool
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"add":       cmdStore,
+		"cas":       cmdStore,
+		"decr":      cmdIncr,
+		"delete":    cmdDelete,
+		"flush_all": cmdFlushAll,
+		"get":       cmdGet,
+		"gets":      cmdGet,
+		"incr":      cmdIncr,
+		"quit":      cmdQuit,
+		"replace":   cmdStore,
+		"set":       cmdStore,
+		"stats":     cmdStats,
+		"touch":     cmdTouch,
+		"version":   cmdVersion,
+	}
+}
+
+func NewMemcacheServer(cfg *config.Config, srv *server.Server) *MemcacheServer {
+
+	s := &MemcacheServer{
+		cfg:     cfg,
+		conns:   make(map[net.Conn]bool),
+		srv:     srv,
+		started: time.Now(),
+
+		opsApiRequestsTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "api_requests_total",
+			Help:      "The total number of processed memcached commands",
+		}),
+
+		opsConnections: promauto.NewGauge(prometheus.GaugeOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "connections",
+			Help:      "The number of open memcached connections",
+		}),
+	}
+
+	s.opsApiRequestsTotal.Add(0.0)
+	s.opsConnections.Set(0.0)
+
+	return s
+}
+
+// Serve accepts connections until Close is called
+func (s *MemcacheServer) Serve() error {
+
+	l, err := net.Listen("tcp", s.cfg.MemcacheBindAddr)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	s.listener = l
+	s.m.Unlock()
+
+	log.Printf("memcache server start listenning at %s", s.cfg.MemcacheBindAddr)
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		go s.handle(nc)
+	}
+}
+
+func (s *MemcacheServer) Close() {
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	for nc := range s.conns {
+		nc.Close()
+	}
+}
+
+func (s *MemcacheServer) handle(nc net.Conn) {
+
+	c := &conn{
+		owner: s,
+		r:     bufio.NewReaderSize(nc, maxLineBytes),
+		w:     bufio.NewWriter(nc),
+	}
+
+	s.m.Lock()
+	s.conns[nc] = true
+	s.m.Unlock()
+
+	atomic.AddUint64(&s.totalConnections, 1)
+	s.opsConnections.Inc()
+	log.Printf("memcache connection from:%s opened", nc.RemoteAddr())
+
+	defer func() {
+		s.m.Lock()
+		delete(s.conns, nc)
+		s.m.Unlock()
+
+		nc.Close()
+		s.opsConnections.Dec()
+		log.Printf("memcache connection from:%s closed", nc.RemoteAddr())
+	}()
+
+	for {
+		line, err := c.readLine()
+		if err == errTooLongLine {
+			c.w.WriteString("CLIENT_ERROR line is too long\r\n")
+			c.w.Flush()
+			return
+		} else if err != nil {
+			return
+		}
+
+		args := bytes.Fields(line)
+		if len(args) == 0 {
+			c.w.WriteString("ERROR\r\n")
+			c.w.Flush()
+			continue
+		}
+
+		s.opsApiRequestsTotal.Inc()
+
+		quit := true
+		if cmd, ok := commands[string(args[0])]; ok {
+			quit = cmd(c, args)
+		} else {
+			c.w.WriteString("ERROR\r\n")
+			quit = false
+		}
+
+		// flush once per batch of pipelined commands
+		if quit || c.r.Buffered() == 0 {
+			if err = c.w.Flush(); err != nil {
+				return
+			}
+		}
+
+		if quit {
+			return
+		}
+	}
+}
+
+func (c *conn) readLine() ([]byte, error) {
+
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errTooLongLine
+	} else if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+func (c *conn) reply(noreply bool, s string) {
+	if !noreply {
+		c.w.WriteString(s)
+		c.w.WriteString("\r\n")
+	}
+}
+
+func (c *conn) clientError(msg string) {
+	c.w.WriteString("CLIENT_ERROR ")
+	c.w.WriteString(msg)
+	c.w.WriteString("\r\n")
+}
+
+func isNoreply(args [][]byte, n int) bool {
+	return len(args) == n+1 && string(args[n]) == "noreply"
+}
+
+func parseUint(b []byte, bits int) (uint64, bool) {
+	n, err := strconv.ParseUint(string(b), 10, bits)
+	return n, err == nil
+}
+
+// expiresAt converts memcached expiration time in seconds to unix time
+// in milliseconds. Negative means already expired. Unlike memcached zero
+// means the default expiration time rather than "never expires" since
+// every record of the cache has an expiration time. The expiration time
+// is limited by MaxExpiresInMs.
+func expiresAt(exptime int64) int64 {
+
+	now := sdk.NowMs()
+
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return -1
+	case exptime > relativeExpiresLimit:
+		if exptime > (now+server.MaxExpiresInMs)/1000 {
+			return now + server.MaxExpiresInMs
+		}
+		if exptime*1000 <= now {
+			return -1
+		}
+		return exptime * 1000
+	default:
+		return now + exptime*1000
+	}
+}
+
+// get <key>*
+// gets <key>*
+func cmdGet(c *conn, args [][]byte) bool {
+
+	if len(args) < 2 {
+		c.w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	withCas := string(args[0]) == "gets"
+	s := c.owner
+
+	for _, key := range args[1:] {
+		atomic.AddUint64(&s.cmdGet, 1)
+
+		// hashes and lists are not available to memcached clients
+		rec, ok := s.srv.Get(string(key))
+		if !ok || !rec.IsPlain() {
+			atomic.AddUint64(&s.getMisses, 1)
+			continue
+		}
+
+		atomic.AddUint64(&s.getHits, 1)
+
+		if withCas {
+			fmt.Fprintf(c.w, "VALUE %s %d %d %d\r\n", key, rec.Flags, len(rec.Value), rec.GetRecId())
+		} else {
+			fmt.Fprintf(c.w, "VALUE %s %d %d\r\n", key, rec.Flags, len(rec.Value))
+		}
+
+		c.w.Write(rec.Value)
+		c.w.WriteString("\r\n")
+	}
+
+	c.w.WriteString("END\r\n")
+	return false
+}
+
+// <command> <key> <flags> <exptime> <bytes> [noreply]
+// cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func cmdStore(c *conn, args [][]byte) bool {
+
+	name := string(args[0])
+	n := 5
+	if name == "cas" {
+		n = 6
+	}
+
+	if len(args) != n && !isNoreply(args, n) {
+		c.w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	noreply := isNoreply(args, n)
+	key := string(args[1])
+	flags, okFlags := parseUint(args[2], 32)
+	exptime, errExptime := strconv.ParseInt(string(args[3]), 10, 64)
+	size, okSize := parseUint(args[4], 31)
+
+	var casUnique uint64
+	okCas := true
+	if name == "cas" {
+		casUnique, okCas = parseUint(args[5], 64)
+	}
+
+	if !okFlags || errExptime != nil || !okSize || !okCas {
+		c.clientError("bad command line format")
+		return true
+	}
+
+	if int64(size) > c.owner.cfg.MaxValueBytes {
+		// swallow the data block to keep the stream in sync
+		if _, err := io.CopyN(ioutil.Discard, c.r, int64(size)+2); err != nil {
+			return true
+		}
+
+		c.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return true
+	}
+
+	if data[size] != '\r' || data[size+1] != '\n' {
+		c.clientError("bad data chunk")
+		return false
+	}
+
+	atomic.AddUint64(&c.owner.cmdSet, 1)
+
+	value := data[:size]
+	expires := expiresAt(exptime)
+	result := "STORED"
+
+	err := c.owner.srv.Update(key, func(rec *sdk.Record, ok bool) int8 {
+
+		switch {
+		case name == "add" && ok:
+			result = "NOT_STORED"
+		case name == "replace" && !ok:
+			result = "NOT_STORED"
+		case name == "cas" && !ok:
+			result = "NOT_FOUND"
+		case name == "cas" && rec.GetRecId() != casUnique:
+			result = "EXISTS"
+		}
+
+		if result != "STORED" {
+			return sdk.UpdateKeep
+		}
+
+		if expires < 0 {
+			// negative expiration time means the item is immediately expired
+			return sdk.UpdateDelete
+		}
+
+		// the stored value replaces hashes, lists and sorted sets too
+		*rec = sdk.Record{
+			Expires: expires,
+			Flags:   uint32(flags),
+			Value:   value,
+		}
+		return sdk.UpdateStore
+	})
+
+	if err == server.ErrValueTooLarge {
+		c.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+	} else if err != nil {
+		c.clientError(err.Error())
+	} else {
+		c.reply(noreply, result)
+	}
+
+	return false
+}
+
+// delete <key> [noreply]
+func cmdDelete(c *conn, args [][]byte) bool {
+
+	if len(args) != 2 && !isNoreply(args, 2) {
+		c.w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	if c.owner.srv.Delete(string(args[1])) {
+		c.reply(isNoreply(args, 2), "DELETED")
+	} else {
+		c.reply(isNoreply(args, 2), "NOT_FOUND")
+	}
+
+	return false
+}
+
+// incr <key> <value> [noreply]
+// decr <key> <value> [noreply]
+// Incrementing wraps around at 2^64, decrementing below 0 gives 0.
+func cmdIncr(c *conn, args [][]byte) bool {
+
+	if len(args) != 3 && !isNoreply(args, 3) {
+		c.w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	delta, ok := parseUint(args[2], 64)
+	if !ok {
+		c.clientError("invalid numeric delta argument")
+		return false
+	}
+
+	decr := string(args[0]) == "decr"
+	result := "NOT_FOUND"
+
+	err := c.owner.srv.Update(string(args[1]), func(rec *sdk.Record, ok bool) int8 {
+
+		if !ok || !rec.IsPlain() {
+			return sdk.UpdateKeep
+		}
+
+		x, err := strconv.ParseUint(string(rec.Value), 10, 64)
+		if err != nil {
+			result = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+			return sdk.UpdateKeep
+		}
+
+		if !decr {
+			x += delta
+		} else if x < delta {
+			x = 0
+		} else {
+			x -= delta
+		}
+
+		result = strconv.FormatUint(x, 10)
+		rec.Value = []byte(result)
+		return sdk.UpdateStore
+	})
+
+	if err != nil {
+		c.clientError(err.Error())
+	} else {
+		c.reply(isNoreply(args, 3), result)
+	}
+
+	return false
+}
+
+// touch <key> <exptime> [noreply]
+func cmdTouch(c *conn, args [][]byte) bool {
+
+	if len(args) != 3 && !isNoreply(args, 3) {
+		c.w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	exptime, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.clientError("invalid exptime argument")
+		return false
+	}
+
+	result := "NOT_FOUND"
+	expires := expiresAt(exptime)
+
+	err = c.owner.srv.Update(string(args[1]), func(rec *sdk.Record, ok bool) int8 {
+
+		if !ok {
+			return sdk.UpdateKeep
+		}
+
+		result = "TOUCHED"
+		if expires < 0 {
+			return sdk.UpdateDelete
+		}
+
+		rec.Expires = expires
+		return sdk.UpdateStore
+	})
+
+	if err != nil {
+		c.clientError(err.Error())
+	} else {
+		c.reply(isNoreply(args, 3), result)
+	}
+
+	return false
+}
+
+// flush_all [delay] [noreply]
+func cmdFlushAll(c *conn, args [][]byte) bool {
+
+	noreply := string(args[len(args)-1]) == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+
+	if len(args) > 2 {
+		c.w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	var delay uint64
+	if len(args) == 2 {
+		var ok bool
+		if delay, ok = parseUint(args[1], 32); !ok {
+			c.clientError("bad command line format")
+			return false
+		}
+	}
+
+	srv := c.owner.srv
+	if delay == 0 {
+		srv.Flush("")
+	} else {
+		time.AfterFunc(time.Duration(delay)*time.Second, func() {
+			srv.Flush("")
+		})
+	}
+
+	c.reply(noreply, "OK")
+	return false
+}
+
+func cmdStats(c *conn, args [][]byte) bool {
+
+	if len(args) > 1 {
+		// stats groups like "stats items" are not supported
+		c.w.WriteString("END\r\n")
+		return false
+	}
+
+	s := c.owner
+	s.m.Lock()
+	currConnections := len(s.conns)
+	s.m.Unlock()
+
+	now := time.Now()
+	stats := []struct {
+		name  string
+		value interface{}
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(now.Sub(s.started).Seconds())},
+		{"time", now.Unix()},
+		{"version", version},
+		{"curr_connections", currConnections},
+		{"total_connections", atomic.LoadUint64(&s.totalConnections)},
+		{"cmd_get", atomic.LoadUint64(&s.cmdGet)},
+		{"cmd_set", atomic.LoadUint64(&s.cmdSet)},
+		{"get_hits", atomic.LoadUint64(&s.getHits)},
+		{"get_misses", atomic.LoadUint64(&s.getMisses)},
+		{"item_size_max", s.cfg.MaxValueBytes},
+	}
+
+	for _, x := range stats {
+		fmt.Fprintf(c.w, "STAT %s %v\r\n", x.name, x.value)
+	}
+
+	c.w.WriteString("END\r\n")
+	return false
+}
+
+func cmdVersion(c *conn, args [][]byte) bool {
+
+	c.w.WriteString("VERSION " + version + "\r\n")
+	return false
+}
+
+func cmdQuit(c *conn, args [][]byte) bool {
+	return true
+}
@@ -0,0 +1,220 @@
+package memcache
+
+import (
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+	"github.com/iaroslavscript/cacheman/lib/server"
+	"github.com/iaroslavscript/cacheman/lib/simplecache"
+	"github.com/iaroslavscript/cacheman/lib/simplereplication"
+	"github.com/iaroslavscript/cacheman/lib/simplescheduler"
+)
+
+var testServer *MemcacheServer
+var testServerOnce sync.Once
+
+// newTestServer returns the server shared by tests because metrics
+// can be registered only once
+func newTestServer() *MemcacheServer {
+
+	testServerOnce.Do(func() {
+		cfg := *config.GetConfig()
+		srv := server.NewServer(&cfg,
+			simplecache.NewSimpleCache(),
+			simplereplication.NewSimpleReplication(&cfg),
+			simplescheduler.NewSimpleExpirer(&cfg),
+		)
+		testServer = NewMemcacheServer(&cfg, srv)
+	})
+
+	return testServer
+}
+
+// newTestConn returns the client side of the connection served by
+// the test server. Keys of previous tests are flushed.
+func newTestConn(t *testing.T) (net.Conn, *server.Server) {
+
+	s := newTestServer()
+	s.srv.Flush("")
+
+	client, nc := net.Pipe()
+	go s.handle(nc)
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { client.Close() })
+
+	return client, s.srv
+}
+
+// roundtrip sends the request and checks the reply. The request is written
+// concurrently since the server may reply before reading the whole request.
+func roundtrip(t *testing.T, client net.Conn, request, wants string) {
+
+	t.Helper()
+
+	go client.Write([]byte(request))
+
+	reply := make([]byte, len(wants))
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("reply to %q error %s", request, err.Error())
+	}
+
+	if string(reply) != wants {
+		t.Errorf("reply to %q = %q; wants %q", request, reply, wants)
+	}
+}
+
+func TestGetSet(t *testing.T) {
+
+	client, _ := newTestConn(t)
+
+	table := []struct {
+		request string
+		wants   string
+	}{
+		{"get a\r\n", "END\r\n"},
+		{"set a 5 0 3\r\nabc\r\n", "STORED\r\n"},
+		{"add a 0 0 1\r\nx\r\n", "NOT_STORED\r\n"},
+		{"replace b 0 0 1\r\nx\r\n", "NOT_STORED\r\n"},
+		{"set b 0 0 2 noreply\r\nxy\r\nget a b c\r\n", "VALUE a 5 3\r\nabc\r\nVALUE b 0 2\r\nxy\r\nEND\r\n"},
+		{"delete b\r\n", "DELETED\r\n"},
+		{"delete b\r\n", "NOT_FOUND\r\n"},
+		{"version\r\n", "VERSION " + version + "\r\n"},
+		{"unknown\r\n", "ERROR\r\n"},
+	}
+
+	for _, x := range table {
+		roundtrip(t, client, x.request, x.wants)
+	}
+}
+
+func TestCas(t *testing.T) {
+
+	client, srv := newTestConn(t)
+
+	roundtrip(t, client, "set a 0 0 1\r\nx\r\n", "STORED\r\n")
+
+	rec, _ := srv.Get("a")
+	id := rec.GetRecId()
+	gets := "VALUE a 0 1 " + itoa(id) + "\r\nx\r\nEND\r\n"
+
+	roundtrip(t, client, "gets a\r\n", gets)
+	roundtrip(t, client, "cas a 0 0 1 "+itoa(id+1000)+"\r\ny\r\n", "EXISTS\r\n")
+	roundtrip(t, client, "cas b 0 0 1 "+itoa(id)+"\r\ny\r\n", "NOT_FOUND\r\n")
+	roundtrip(t, client, "cas a 0 0 1 "+itoa(id)+"\r\ny\r\n", "STORED\r\n")
+
+	// the record id changes on every modification
+	roundtrip(t, client, "cas a 0 0 1 "+itoa(id)+"\r\nz\r\n", "EXISTS\r\n")
+	roundtrip(t, client, "get a\r\n", "VALUE a 0 1\r\ny\r\nEND\r\n")
+}
+
+func TestIncr(t *testing.T) {
+
+	client, _ := newTestConn(t)
+
+	table := []struct {
+		request string
+		wants   string
+	}{
+		{"incr a 1\r\n", "NOT_FOUND\r\n"},
+		{"set a 0 0 2\r\n10\r\n", "STORED\r\n"},
+		{"incr a 5\r\n", "15\r\n"},
+		{"decr a 20\r\n", "0\r\n"},
+		{"set a 0 0 20\r\n18446744073709551615\r\n", "STORED\r\n"},
+		{"incr a 2\r\n", "1\r\n"},
+		{"incr a x\r\n", "CLIENT_ERROR invalid numeric delta argument\r\n"},
+		{"set b 0 0 1\r\nx\r\n", "STORED\r\n"},
+		{"incr b 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+	}
+
+	for _, x := range table {
+		roundtrip(t, client, x.request, x.wants)
+	}
+}
+
+func TestTouch(t *testing.T) {
+
+	client, srv := newTestConn(t)
+
+	roundtrip(t, client, "touch a 100\r\n", "NOT_FOUND\r\n")
+	roundtrip(t, client, "set a 0 0 1\r\nx\r\n", "STORED\r\n")
+	roundtrip(t, client, "touch a 100\r\n", "TOUCHED\r\n")
+
+	rec, _ := srv.Get("a")
+	if d := rec.Expires - sdk.NowMs(); d < 99000 || d > 100000 {
+		t.Errorf("the touched key expires in %d ms; wants 100000", d)
+	}
+
+	roundtrip(t, client, "touch a -1\r\n", "TOUCHED\r\n")
+	roundtrip(t, client, "get a\r\n", "END\r\n")
+}
+
+func TestFlushAll(t *testing.T) {
+
+	client, _ := newTestConn(t)
+
+	roundtrip(t, client, "set a 0 0 1\r\nx\r\n", "STORED\r\n")
+	roundtrip(t, client, "set b 0 0 1\r\nx\r\n", "STORED\r\n")
+	roundtrip(t, client, "flush_all\r\n", "OK\r\n")
+	roundtrip(t, client, "get a b\r\n", "END\r\n")
+	roundtrip(t, client, "flush_all x\r\n", "CLIENT_ERROR bad command line format\r\n")
+}
+
+func TestFraming(t *testing.T) {
+
+	table := []struct {
+		request string
+		wants   string
+	}{
+		{"set a 0 0 1\r\nxyz\r\n", "CLIENT_ERROR bad data chunk\r\nERROR\r\n"},
+		{"set a 0 0\r\n", "ERROR\r\n"},
+		{"set a x 0 1\r\nx\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"get " + strings.Repeat("a", maxLineBytes) + "\r\n", "CLIENT_ERROR line is too long\r\n"},
+	}
+
+	for _, x := range table {
+		client, _ := newTestConn(t)
+		roundtrip(t, client, x.request, x.wants)
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+
+	now := sdk.NowMs()
+
+	if x := expiresAt(0); x != 0 {
+		t.Errorf("expiresAt(0) = %d; wants the default expiration time 0", x)
+	}
+
+	if x := expiresAt(-1); x != -1 {
+		t.Errorf("expiresAt(-1) = %d; wants -1", x)
+	}
+
+	if x := expiresAt(60); x < now+60000 || x > sdk.NowMs()+60000 {
+		t.Errorf("expiresAt(60) = %d; wants %d", x, now+60000)
+	}
+
+	if x := expiresAt(now/1000 + 60); x != (now/1000+60)*1000 {
+		t.Errorf("expiresAt(%d) = %d; wants the unix time in ms", now/1000+60, x)
+	}
+
+	if x := expiresAt(math.MaxInt64); x < now+server.MaxExpiresInMs || x > sdk.NowMs()+server.MaxExpiresInMs {
+		t.Errorf("expiresAt(%d) = %d; wants %d", int64(math.MaxInt64), x, now+server.MaxExpiresInMs)
+	}
+
+	if x := expiresAt(relativeExpiresLimit + 1); x != -1 {
+		t.Errorf("expiresAt(%d) = %d; wants -1 for the past unix time", relativeExpiresLimit+1, x)
+	}
+}
+
+func itoa(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
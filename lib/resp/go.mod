@@ -0,0 +1,25 @@
+module github.com/iaroslavscript/cacheman/lib/resp
+
+go 1.15
+
+replace github.com/iaroslavscript/cacheman/lib/config => ../config
+
+replace github.com/iaroslavscript/cacheman/lib/sdk => ../sdk
+
+replace github.com/iaroslavscript/cacheman/lib/server => ../server
+
+replace github.com/iaroslavscript/cacheman/lib/simplecache => ../simplecache
+
+replace github.com/iaroslavscript/cacheman/lib/simplereplication => ../simplereplication
+
+replace github.com/iaroslavscript/cacheman/lib/simplescheduler => ../simplescheduler
+
+require (
+	github.com/iaroslavscript/cacheman/lib/config v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/sdk v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/server v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/simplecache v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/simplereplication v0.0.0-00010101000000-000000000000
+	github.com/iaroslavscript/cacheman/lib/simplescheduler v0.0.0-00010101000000-000000000000
+	github.com/prometheus/client_golang v1.8.0
+)
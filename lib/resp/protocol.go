@@ -0,0 +1,230 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Limits of incoming commands. Arguments of a command may take up to
+// maxLineBytes besides the bulk limit, enough for keys and options
+// of the command setting the largest value.
+const (
+	maxLineBytes = 64 * 1024
+	maxArrayLen  = 1024 * 1024
+)
+
+var errProtocol = errors.New("Protocol error")
+
+// reader parses commands sent as RESP arrays of bulk strings
+// or as inline commands separated by spaces.
+type reader struct {
+	r         *bufio.Reader
+	bulkLimit int64
+	cmdLimit  int64 // total bytes of arguments of a command
+}
+
+func newReader(r io.Reader, bulkLimit int64) *reader {
+	return &reader{
+		r:         bufio.NewReaderSize(r, maxLineBytes),
+		bulkLimit: bulkLimit,
+		cmdLimit:  bulkLimit + maxLineBytes,
+	}
+}
+
+func (rd *reader) buffered() int {
+	return rd.r.Buffered()
+}
+
+// peek waits until the next byte is received without reading it
+func (rd *reader) peek() error {
+	_, err := rd.r.Peek(1)
+	return err
+}
+
+// readLine returns the line without trailing \r\n. The result is valid only
+// until the next read.
+func (rd *reader) readLine() ([]byte, error) {
+
+	line, err := rd.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%s: too long line", errProtocol.Error())
+	} else if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+func (rd *reader) readInt(line []byte) (int64, error) {
+
+	n, err := strconv.ParseInt(string(line), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid length", errProtocol.Error())
+	}
+
+	return n, nil
+}
+
+// readCommand returns arguments of the next command. Empty inline commands
+// are skipped.
+func (rd *reader) readCommand() ([][]byte, error) {
+
+	for {
+		line, err := rd.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] != '*' {
+			fields := bytes.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			args := make([][]byte, len(fields))
+			for i, x := range fields {
+				args[i] = append([]byte(nil), x...)
+			}
+
+			return args, nil
+		}
+
+		n, err := rd.readInt(line[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		if n > maxArrayLen {
+			return nil, fmt.Errorf("%s: too many arguments", errProtocol.Error())
+		} else if n < 1 {
+			continue
+		}
+
+		// args grow as they are read, so the announced length
+		// doesn't allocate memory by itself
+		var args [][]byte
+		left := rd.cmdLimit
+
+		for i := int64(0); i < n; i++ {
+			arg, err := rd.readBulk(left)
+			if err != nil {
+				return nil, err
+			}
+
+			left -= int64(len(arg))
+			args = append(args, arg)
+		}
+
+		return args, nil
+	}
+}
+
+// readBulk returns the bulk string not longer than the bulk limit and left bytes
+func (rd *reader) readBulk(left int64) ([]byte, error) {
+
+	line, err := rd.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '$' {
+		return nil, fmt.Errorf("%s: expected '$'", errProtocol.Error())
+	}
+
+	n, err := rd.readInt(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 || n > rd.bulkLimit {
+		return nil, fmt.Errorf("%s: invalid bulk length", errProtocol.Error())
+	} else if n > left {
+		return nil, fmt.Errorf("%s: too large command", errProtocol.Error())
+	}
+
+	data := make([]byte, n+2)
+	if _, err = io.ReadFull(rd.r, data); err != nil {
+		return nil, err
+	}
+
+	if data[n] != '\r' || data[n+1] != '\n' {
+		return nil, fmt.Errorf("%s: expected CRLF", errProtocol.Error())
+	}
+
+	return data[:n], nil
+}
+
+// writer serializes replies in RESP2 or RESP3 depending on proto
+type writer struct {
+	w     *bufio.Writer
+	proto int
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{
+		w:     bufio.NewWriter(w),
+		proto: 2,
+	}
+}
+
+func (wr *writer) flush() error {
+	return wr.w.Flush()
+}
+
+func (wr *writer) writeHeader(prefix byte, n int64) {
+	wr.w.WriteByte(prefix)
+	wr.w.WriteString(strconv.FormatInt(n, 10))
+	wr.w.WriteString("\r\n")
+}
+
+func (wr *writer) writeSimple(s string) {
+	wr.w.WriteByte('+')
+	wr.w.WriteString(s)
+	wr.w.WriteString("\r\n")
+}
+
+func (wr *writer) writeError(s string) {
+	wr.w.WriteByte('-')
+	wr.w.WriteString(s)
+	wr.w.WriteString("\r\n")
+}
+
+func (wr *writer) writeInt(n int64) {
+	wr.writeHeader(':', n)
+}
+
+func (wr *writer) writeBulk(b []byte) {
+	wr.writeHeader('$', int64(len(b)))
+	wr.w.Write(b)
+	wr.w.WriteString("\r\n")
+}
+
+func (wr *writer) writeNull() {
+	if wr.proto == 3 {
+		wr.w.WriteString("_\r\n")
+	} else {
+		wr.w.WriteString("$-1\r\n")
+	}
+}
+
+func (wr *writer) writeArray(n int) {
+	wr.writeHeader('*', int64(n))
+}
+
+// writeMap starts a map of n pairs. RESP2 has no maps so a flat array of
+// keys and values is used instead.
+func (wr *writer) writeMap(n int) {
+	if wr.proto == 3 {
+		wr.writeHeader('%', int64(n))
+	} else {
+		wr.writeHeader('*', int64(2*n))
+	}
+}
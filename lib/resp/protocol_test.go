@@ -0,0 +1,85 @@
+package resp
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$6\r\nx\r\ny z\r\n" +
+		"\r\n" +
+		"PING  hello\r\n"
+
+	rd := newReader(strings.NewReader(input), 1024)
+
+	table := [][]string{
+		{"SET", "a", "x\r\ny z"},
+		{"PING", "hello"},
+	}
+
+	for _, wants := range table {
+		args, err := rd.readCommand()
+		if err != nil {
+			t.Fatalf("readCommand() error %s", err.Error())
+		}
+
+		if len(args) != len(wants) {
+			t.Fatalf("len(args) = %d; wants %d", len(args), len(wants))
+		}
+
+		for i, x := range wants {
+			if string(args[i]) != x {
+				t.Errorf("args[%d] = %q; wants %q", i, args[i], x)
+			}
+		}
+	}
+}
+
+func TestReadCommandBulkLimit(t *testing.T) {
+	rd := newReader(strings.NewReader("*1\r\n$5\r\nhello\r\n"), 4)
+
+	if _, err := rd.readCommand(); err == nil {
+		t.Errorf("readCommand() wants error for bulk longer than limit")
+	}
+}
+
+func TestReadCommandLimit(t *testing.T) {
+	// every argument fits the bulk limit but all of them don't fit the command limit
+	arg := strings.Repeat("x", 1024)
+	input := "*" + strconv.Itoa(maxLineBytes/1024+2) + "\r\n" +
+		strings.Repeat("$1024\r\n"+arg+"\r\n", maxLineBytes/1024+2)
+
+	rd := newReader(strings.NewReader(input), 1024)
+	if _, err := rd.readCommand(); err == nil {
+		t.Errorf("readCommand() wants error for arguments longer than the command limit")
+	}
+
+	input = "*" + strconv.Itoa(maxLineBytes/1024+1) + "\r\n" +
+		strings.Repeat("$1024\r\n"+arg+"\r\n", maxLineBytes/1024+1)
+
+	rd = newReader(strings.NewReader(input), 1024)
+	if args, err := rd.readCommand(); err != nil {
+		t.Errorf("readCommand() error %s", err.Error())
+	} else if len(args) != maxLineBytes/1024+1 {
+		t.Errorf("len(args) = %d; wants %d", len(args), maxLineBytes/1024+1)
+	}
+}
+
+func TestWriteNullAndMap(t *testing.T) {
+	var buf bytes.Buffer
+	wr := newWriter(&buf)
+
+	wr.writeNull()
+	wr.writeMap(1)
+	wr.proto = 3
+	wr.writeNull()
+	wr.writeMap(1)
+	wr.flush()
+
+	wants := "$-1\r\n*2\r\n_\r\n%1\r\n"
+	if buf.String() != wants {
+		t.Errorf("output = %q; wants %q", buf.String(), wants)
+	}
+}
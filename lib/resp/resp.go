@@ -0,0 +1,977 @@
+package resp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+	"github.com/iaroslavscript/cacheman/lib/server"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsSubsystem = "resp"
+
+// The version of Redis reported to clients
+const redisVersion = "7.0.0"
+
+// RespServer serves a subset of Redis commands over RESP2/RESP3
+// on top of the same operations as the HTTP server.
+type RespServer struct {
+	cfg      *config.Config
+	conns    map[net.Conn]*conn
+	listener net.Listener
+	m        sync.Mutex
+	nextId   int64
+	srv      *server.Server
+	started  time.Time
+
+	opsApiRequestsTotal prometheus.Counter
+	opsConnections      prometheus.Gauge
+}
+
+type conn struct {
+	id     int64
+	nc     net.Conn
+	owner  *RespServer
+	rd     *reader
+	wr     *writer
+	ctx    context.Context // canceled when the connection is closed
+	cancel context.CancelFunc
+}
+
+type command struct {
+	arity int // negative arity means at least -arity arguments
+	f     func(c *conn, args [][]byte) bool
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"DEL":              {-2, cmdDel},
+		"BLPOP":            {3, cmdBpop},
+		"BRPOP":            {3, cmdBpop},
+		"EXISTS":           {-2, cmdExists},
+		"EXPIRE":           {3, cmdExpire},
+		"GET":              {2, cmdGet},
+		"HDEL":             {-3, cmdHdel},
+		"HELLO":            {-1, cmdHello},
+		"HGET":             {3, cmdHget},
+		"HGETALL":          {2, cmdHgetall},
+		"HINCRBY":          {4, cmdHincrby},
+		"HSET":             {-4, cmdHset},
+		"INCR":             {2, cmdIncr},
+		"INFO":             {-1, cmdInfo},
+		"LLEN":             {2, cmdLlen},
+		"LPOP":             {2, cmdPop},
+		"LPUSH":            {-3, cmdPush},
+		"LRANGE":           {4, cmdLrange},
+		"LTRIM":            {4, cmdLtrim},
+		"MGET":             {-2, cmdMget},
+		"MSET":             {-3, cmdMset},
+		"PEXPIRE":          {3, cmdExpire},
+		"PING":             {-1, cmdPing},
+		"PTTL":             {2, cmdTtl},
+		"QUIT":             {1, cmdQuit},
+		"RPOP":             {2, cmdPop},
+		"RPUSH":            {-3, cmdPush},
+		"SET":              {-3, cmdSet},
+		"TTL":              {2, cmdTtl},
+		"ZADD":             {-4, cmdZadd},
+		"ZCARD":            {2, cmdZcard},
+		"ZRANGE":           {-4, cmdZrange},
+		"ZRANGEBYSCORE":    {-4, cmdZrangebyscore},
+		"ZRANK":            {3, cmdZrank},
+		"ZREM":             {-3, cmdZrem},
+		"ZREVRANGE":        {-4, cmdZrange},
+		"ZREVRANGEBYSCORE": {-4, cmdZrangebyscore},
+		"ZREVRANK":         {3, cmdZrank},
+		"ZSCORE":           {3, cmdZscore},
+	}
+}
+
+func NewRespServer(cfg *config.Config, srv *server.Server) *RespServer {
+
+	s := &RespServer{
+		cfg:     cfg,
+		conns:   make(map[net.Conn]*conn),
+		srv:     srv,
+		started: time.Now(),
+
+		opsApiRequestsTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "api_requests_total",
+			Help:      "The total number of processed RESP commands",
+		}),
+
+		opsConnections: promauto.NewGauge(prometheus.GaugeOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "connections",
+			Help:      "The number of open RESP connections",
+		}),
+	}
+
+	s.opsApiRequestsTotal.Add(0.0)
+	s.opsConnections.Set(0.0)
+
+	return s
+}
+
+// Serve accepts connections until Close is called
+func (s *RespServer) Serve() error {
+
+	l, err := net.Listen("tcp", s.cfg.RespBindAddr)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	s.listener = l
+	s.m.Unlock()
+
+	log.Printf("resp server start listenning at %s", s.cfg.RespBindAddr)
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		go s.handle(nc)
+	}
+}
+
+func (s *RespServer) Close() {
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	// blocked commands don't read the connection so they are canceled too
+	for nc, c := range s.conns {
+		c.cancel()
+		nc.Close()
+	}
+}
+
+func (s *RespServer) handle(nc net.Conn) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.m.Lock()
+	s.nextId++
+	c := &conn{
+		id:     s.nextId,
+		nc:     nc,
+		owner:  s,
+		rd:     newReader(nc, s.cfg.MaxValueBytes),
+		wr:     newWriter(nc),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	s.conns[nc] = c
+	s.m.Unlock()
+
+	s.opsConnections.Inc()
+	log.Printf("resp connection:%d from:%s opened", c.id, nc.RemoteAddr())
+
+	defer func() {
+		s.m.Lock()
+		delete(s.conns, nc)
+		s.m.Unlock()
+
+		cancel()
+		nc.Close()
+		s.opsConnections.Dec()
+		log.Printf("resp connection:%d closed", c.id)
+	}()
+
+	for {
+		args, err := c.rd.readCommand()
+		if err != nil {
+			if err != io.EOF {
+				c.wr.writeError("ERR " + err.Error())
+				c.wr.flush()
+			}
+			return
+		}
+
+		s.opsApiRequestsTotal.Inc()
+		quit := c.exec(args)
+
+		// flush once per batch of pipelined commands
+		if quit || c.rd.buffered() == 0 {
+			if err = c.wr.flush(); err != nil {
+				return
+			}
+		}
+
+		if quit {
+			return
+		}
+	}
+}
+
+// exec runs the command and reports whether the connection should be closed
+func (c *conn) exec(args [][]byte) bool {
+
+	name := strings.ToUpper(string(args[0]))
+	cmd, ok := commands[name]
+
+	if !ok {
+		c.wr.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		c.wr.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command",
+			strings.ToLower(name)))
+		return false
+	}
+
+	return cmd.f(c, args)
+}
+
+// watchClose returns the context of the connection which is also canceled
+// when the client closes the connection while a command blocks. The returned
+// function should be called before reading the next command.
+func (c *conn) watchClose() (context.Context, func()) {
+
+	// a pipelined command is already read so the client is alive
+	if c.rd.buffered() > 0 {
+		return c.ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		// peek doesn't consume the next command sent by the client
+		if err := c.rd.peek(); err != nil {
+			cancel()
+		}
+	}()
+
+	return ctx, func() {
+		cancel()
+
+		// interrupt the peek and wait for it since the reader isn't safe
+		// for concurrent use
+		c.nc.SetReadDeadline(time.Unix(1, 0))
+		<-done
+		c.nc.SetReadDeadline(time.Time{})
+	}
+}
+
+func (c *conn) writeErr(err error) {
+	if err == server.ErrWrongType {
+		c.wr.writeError("WRONGTYPE " + err.Error())
+		return
+	}
+
+	c.wr.writeError("ERR " + err.Error())
+}
+
+func parseInt(b []byte) (int64, bool) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	return n, err == nil
+}
+
+// secToMs converts seconds to milliseconds and reports whether the result
+// fits MaxExpiresInMs. Negative seconds are converted to -1.
+func secToMs(sec int64) (int64, bool) {
+
+	if sec < 0 {
+		return -1, true
+	}
+
+	return sec * 1000, sec <= server.MaxExpiresInMs/1000
+}
+
+func cmdPing(c *conn, args [][]byte) bool {
+
+	if len(args) > 1 {
+		c.wr.writeBulk(args[1])
+	} else {
+		c.wr.writeSimple("PONG")
+	}
+
+	return false
+}
+
+func cmdQuit(c *conn, args [][]byte) bool {
+
+	c.wr.writeSimple("OK")
+	return true
+}
+
+// HELLO [protover [SETNAME clientname]]
+func cmdHello(c *conn, args [][]byte) bool {
+
+	if len(args) > 1 {
+		proto, ok := parseInt(args[1])
+		if !ok || proto < 2 || proto > 3 {
+			c.wr.writeError("NOPROTO unsupported protocol version")
+			return false
+		}
+
+		c.wr.proto = int(proto)
+	}
+
+	c.wr.writeMap(7)
+	c.wr.writeBulk([]byte("server"))
+	c.wr.writeBulk([]byte("redis"))
+	c.wr.writeBulk([]byte("version"))
+	c.wr.writeBulk([]byte(redisVersion))
+	c.wr.writeBulk([]byte("proto"))
+	c.wr.writeInt(int64(c.wr.proto))
+	c.wr.writeBulk([]byte("id"))
+	c.wr.writeInt(c.id)
+	c.wr.writeBulk([]byte("mode"))
+	c.wr.writeBulk([]byte("standalone"))
+	c.wr.writeBulk([]byte("role"))
+	c.wr.writeBulk([]byte("master"))
+	c.wr.writeBulk([]byte("modules"))
+	c.wr.writeArray(0)
+
+	return false
+}
+
+func cmdInfo(c *conn, args [][]byte) bool {
+
+	s := c.owner
+	s.m.Lock()
+	clients := len(s.conns)
+	s.m.Unlock()
+
+	info := fmt.Sprintf("# Server\r\n"+
+		"redis_version:%s\r\n"+
+		"redis_mode:standalone\r\n"+
+		"uptime_in_seconds:%d\r\n"+
+		"\r\n"+
+		"# Clients\r\n"+
+		"connected_clients:%d\r\n",
+		redisVersion,
+		int64(time.Since(s.started).Seconds()),
+		clients,
+	)
+
+	c.wr.writeBulk([]byte(info))
+	return false
+}
+
+func cmdGet(c *conn, args [][]byte) bool {
+
+	if rec, ok := c.owner.srv.Get(string(args[1])); ok && !rec.IsPlain() {
+		c.writeErr(server.ErrWrongType)
+	} else if ok {
+		c.wr.writeBulk(rec.Value)
+	} else {
+		c.wr.writeNull()
+	}
+
+	return false
+}
+
+func cmdMget(c *conn, args [][]byte) bool {
+
+	c.wr.writeArray(len(args) - 1)
+	for _, key := range args[1:] {
+		cmdGet(c, [][]byte{nil, key})
+	}
+
+	return false
+}
+
+// SET key value [EX seconds | PX milliseconds] [NX | XX]
+func cmdSet(c *conn, args [][]byte) bool {
+
+	var expiresInMs int64
+	mode := server.SetAlways
+
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+
+		switch {
+		case (opt == "EX" || opt == "PX") && expiresInMs == 0 && i+1 < len(args):
+			i++
+			n, ok := parseInt(args[i])
+			if ok && opt == "EX" {
+				n, ok = secToMs(n)
+			}
+
+			if !ok || n < 1 || n > server.MaxExpiresInMs {
+				c.wr.writeError("ERR invalid expire time in 'set' command")
+				return false
+			}
+			expiresInMs = n
+
+		case opt == "NX" && mode == server.SetAlways:
+			mode = server.SetIfAbsent
+		case opt == "XX" && mode == server.SetAlways:
+			mode = server.SetIfExists
+		default:
+			c.wr.writeError("ERR syntax error")
+			return false
+		}
+	}
+
+	ok, err := c.owner.srv.Set(string(args[1]), args[2], expiresInMs, mode)
+	if err != nil {
+		c.writeErr(err)
+	} else if ok {
+		c.wr.writeSimple("OK")
+	} else {
+		c.wr.writeNull()
+	}
+
+	return false
+}
+
+// MSET key value [key value ...]
+// Keys are set one by one, an invalid key stops the command.
+func cmdMset(c *conn, args [][]byte) bool {
+
+	if len(args)%2 != 1 {
+		c.wr.writeError("ERR wrong number of arguments for 'mset' command")
+		return false
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		if _, err := c.owner.srv.Set(string(args[i]), args[i+1], 0, server.SetAlways); err != nil {
+			c.writeErr(err)
+			return false
+		}
+	}
+
+	c.wr.writeSimple("OK")
+	return false
+}
+
+func cmdDel(c *conn, args [][]byte) bool {
+
+	var n int64
+	for _, key := range args[1:] {
+		if c.owner.srv.Delete(string(key)) {
+			n++
+		}
+	}
+
+	c.wr.writeInt(n)
+	return false
+}
+
+func cmdExists(c *conn, args [][]byte) bool {
+
+	var n int64
+	for _, key := range args[1:] {
+		if c.owner.srv.Exists(string(key)) {
+			n++
+		}
+	}
+
+	c.wr.writeInt(n)
+	return false
+}
+
+// TTL key returns seconds, PTTL key returns milliseconds
+func cmdTtl(c *conn, args [][]byte) bool {
+
+	if ttl, ok := c.owner.srv.TTL(string(args[1])); ok {
+		if strings.ToUpper(string(args[0])) == "TTL" {
+			// round to the nearest second
+			ttl = (ttl + 500) / 1000
+		}
+		c.wr.writeInt(ttl)
+	} else {
+		c.wr.writeInt(-2)
+	}
+
+	return false
+}
+
+// EXPIRE key seconds, PEXPIRE key milliseconds
+func cmdExpire(c *conn, args [][]byte) bool {
+
+	n, ok := parseInt(args[2])
+	if !ok {
+		c.wr.writeError("ERR value is not an integer or out of range")
+		return false
+	}
+
+	if strings.ToUpper(string(args[0])) == "EXPIRE" {
+		n, ok = secToMs(n)
+	}
+
+	if !ok || n > server.MaxExpiresInMs {
+		c.wr.writeError(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(string(args[0]))))
+		return false
+	}
+
+	if c.owner.srv.Expire(string(args[1]), n) {
+		c.wr.writeInt(1)
+	} else {
+		c.wr.writeInt(0)
+	}
+
+	return false
+}
+
+func cmdIncr(c *conn, args [][]byte) bool {
+
+	n, err := c.owner.srv.Incr(string(args[1]), 1)
+	if err != nil {
+		c.writeErr(err)
+	} else {
+		c.wr.writeInt(n)
+	}
+
+	return false
+}
+
+func cmdHget(c *conn, args [][]byte) bool {
+
+	value, ok, err := c.owner.srv.GetField(string(args[1]), string(args[2]))
+	switch {
+	case err != nil:
+		c.writeErr(err)
+	case ok:
+		c.wr.writeBulk(value)
+	default:
+		c.wr.writeNull()
+	}
+
+	return false
+}
+
+func cmdHgetall(c *conn, args [][]byte) bool {
+
+	fields, _, err := c.owner.srv.GetFields(string(args[1]))
+	if err != nil {
+		c.writeErr(err)
+		return false
+	}
+
+	c.wr.writeMap(len(fields))
+	for k, v := range fields {
+		c.wr.writeBulk([]byte(k))
+		c.wr.writeBulk(v)
+	}
+
+	return false
+}
+
+// HSET key field value [field value ...]
+// Every field is set atomically but the command as a whole is not atomic.
+func cmdHset(c *conn, args [][]byte) bool {
+
+	if len(args)%2 != 0 {
+		c.wr.writeError("ERR wrong number of arguments for 'hset' command")
+		return false
+	}
+
+	var n int64
+	for i := 2; i < len(args); i += 2 {
+		created, err := c.owner.srv.SetField(string(args[1]), string(args[i]), args[i+1], 0)
+		if err != nil {
+			c.writeErr(err)
+			return false
+		}
+
+		if created {
+			n++
+		}
+	}
+
+	c.wr.writeInt(n)
+	return false
+}
+
+func cmdHdel(c *conn, args [][]byte) bool {
+
+	var n int64
+	for _, field := range args[2:] {
+		found, err := c.owner.srv.DeleteField(string(args[1]), string(field))
+		if err != nil {
+			c.writeErr(err)
+			return false
+		}
+
+		if found {
+			n++
+		}
+	}
+
+	c.wr.writeInt(n)
+	return false
+}
+
+func cmdHincrby(c *conn, args [][]byte) bool {
+
+	delta, ok := parseInt(args[3])
+	if !ok {
+		c.writeErr(server.ErrNotInteger)
+		return false
+	}
+
+	n, err := c.owner.srv.IncrField(string(args[1]), string(args[2]), delta)
+	if err != nil {
+		c.writeErr(err)
+	} else {
+		c.wr.writeInt(n)
+	}
+
+	return false
+}
+
+// listSide returns the side of the list used by the command LPUSH, RPOP etc.
+func listSide(name []byte) int8 {
+
+	switch strings.ToUpper(string(name))[:2] {
+	case "LP", "BL":
+		return server.ListHead
+	}
+
+	return server.ListTail
+}
+
+// LPUSH key value [value ...]
+// RPUSH key value [value ...]
+func cmdPush(c *conn, args [][]byte) bool {
+
+	n, err := c.owner.srv.Push(string(args[1]), listSide(args[0]), args[2:], 0)
+	if err != nil {
+		c.writeErr(err)
+	} else {
+		c.wr.writeInt(n)
+	}
+
+	return false
+}
+
+// LPOP key
+// RPOP key
+// The count argument is not supported.
+func cmdPop(c *conn, args [][]byte) bool {
+
+	value, ok, err := c.owner.srv.Pop(string(args[1]), listSide(args[0]))
+	switch {
+	case err != nil:
+		c.writeErr(err)
+	case ok:
+		c.wr.writeBulk(value)
+	default:
+		c.wr.writeNull()
+	}
+
+	return false
+}
+
+// BLPOP key timeout
+// BRPOP key timeout
+// Only one key is supported. The timeout is limited by max_wait_sec,
+// zero timeout means max_wait_sec too.
+func cmdBpop(c *conn, args [][]byte) bool {
+
+	sec, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || sec < 0 {
+		c.wr.writeError("ERR timeout is not a float or out of range")
+		return false
+	}
+
+	wait := time.Duration(sec * float64(time.Second))
+	if max := time.Duration(c.owner.cfg.MaxWaitSec) * time.Second; wait == 0 || wait > max {
+		wait = max
+	}
+
+	// send replies of pipelined commands before blocking
+	c.wr.flush()
+
+	ctx, stop := c.watchClose()
+	value, ok, err := c.owner.srv.BlockingPop(ctx, string(args[1]), listSide(args[0]), wait)
+	stop()
+
+	switch {
+	case err != nil:
+		c.writeErr(err)
+	case ok:
+		c.wr.writeArray(2)
+		c.wr.writeBulk(args[1])
+		c.wr.writeBulk(value)
+	default:
+		c.wr.writeNull()
+	}
+
+	return false
+}
+
+func cmdLlen(c *conn, args [][]byte) bool {
+
+	n, err := c.owner.srv.Length(string(args[1]))
+	if err != nil {
+		c.writeErr(err)
+	} else {
+		c.wr.writeInt(n)
+	}
+
+	return false
+}
+
+func cmdLrange(c *conn, args [][]byte) bool {
+
+	start, ok1 := parseInt(args[2])
+	stop, ok2 := parseInt(args[3])
+	if !ok1 || !ok2 {
+		c.writeErr(server.ErrNotInteger)
+		return false
+	}
+
+	items, _, err := c.owner.srv.Range(string(args[1]), start, stop)
+	if err != nil {
+		c.writeErr(err)
+		return false
+	}
+
+	c.wr.writeArray(len(items))
+	for _, x := range items {
+		c.wr.writeBulk(x)
+	}
+
+	return false
+}
+
+func cmdLtrim(c *conn, args [][]byte) bool {
+
+	start, ok1 := parseInt(args[2])
+	stop, ok2 := parseInt(args[3])
+	if !ok1 || !ok2 {
+		c.writeErr(server.ErrNotInteger)
+		return false
+	}
+
+	if err := c.owner.srv.Trim(string(args[1]), start, stop); err != nil {
+		c.writeErr(err)
+	} else {
+		c.wr.writeSimple("OK")
+	}
+
+	return false
+}
+
+func formatScore(x float64) []byte {
+	return []byte(strconv.FormatFloat(x, 'g', -1, 64))
+}
+
+// parseScore parses the score or the bound of the range of scores.
+// Exclusive bounds like "(1" are not supported.
+func parseScore(b []byte) (float64, bool) {
+	x, err := strconv.ParseFloat(string(b), 64)
+	return x, err == nil && !math.IsNaN(x)
+}
+
+// withScores reports whether the only option of the command is WITHSCORES
+func withScores(args [][]byte, n int) (bool, bool) {
+
+	switch {
+	case len(args) == n:
+		return false, true
+	case len(args) == n+1 && strings.ToUpper(string(args[n])) == "WITHSCORES":
+		return true, true
+	}
+
+	return false, false
+}
+
+func (c *conn) writeZsetItems(items []sdk.SortedSetItem, scores bool) {
+
+	if scores {
+		c.wr.writeArray(2 * len(items))
+	} else {
+		c.wr.writeArray(len(items))
+	}
+
+	for _, x := range items {
+		c.wr.writeBulk([]byte(x.Member))
+		if scores {
+			c.wr.writeBulk(formatScore(x.Score))
+		}
+	}
+}
+
+// ZADD key score member [score member ...]
+// Options NX, XX, GT, LT, CH and INCR are not supported.
+func cmdZadd(c *conn, args [][]byte) bool {
+
+	if len(args)%2 != 0 {
+		c.wr.writeError("ERR syntax error")
+		return false
+	}
+
+	items := make([]sdk.SortedSetItem, 0, len(args)/2-1)
+	for i := 2; i < len(args); i += 2 {
+		score, ok := parseScore(args[i])
+		if !ok {
+			c.wr.writeError("ERR value is not a valid float")
+			return false
+		}
+
+		items = append(items, sdk.SortedSetItem{Member: string(args[i+1]), Score: score})
+	}
+
+	n, err := c.owner.srv.AddScores(string(args[1]), items, 0)
+	if err != nil {
+		c.writeErr(err)
+	} else {
+		c.wr.writeInt(n)
+	}
+
+	return false
+}
+
+func cmdZrem(c *conn, args [][]byte) bool {
+
+	members := make([]string, len(args)-2)
+	for i, x := range args[2:] {
+		members[i] = string(x)
+	}
+
+	n, err := c.owner.srv.RemoveMembers(string(args[1]), members)
+	if err != nil {
+		c.writeErr(err)
+	} else {
+		c.wr.writeInt(n)
+	}
+
+	return false
+}
+
+func cmdZscore(c *conn, args [][]byte) bool {
+
+	score, ok, err := c.owner.srv.Score(string(args[1]), string(args[2]))
+	switch {
+	case err != nil:
+		c.writeErr(err)
+	case ok:
+		c.wr.writeBulk(formatScore(score))
+	default:
+		c.wr.writeNull()
+	}
+
+	return false
+}
+
+// ZRANK key member
+// ZREVRANK key member
+func cmdZrank(c *conn, args [][]byte) bool {
+
+	rev := strings.ToUpper(string(args[0])) == "ZREVRANK"
+
+	rank, _, ok, err := c.owner.srv.Rank(string(args[1]), string(args[2]), rev)
+	switch {
+	case err != nil:
+		c.writeErr(err)
+	case ok:
+		c.wr.writeInt(rank)
+	default:
+		c.wr.writeNull()
+	}
+
+	return false
+}
+
+func cmdZcard(c *conn, args [][]byte) bool {
+
+	n, err := c.owner.srv.Cardinality(string(args[1]))
+	if err != nil {
+		c.writeErr(err)
+	} else {
+		c.wr.writeInt(n)
+	}
+
+	return false
+}
+
+// ZRANGE key start stop [WITHSCORES]
+// ZREVRANGE key start stop [WITHSCORES]
+// Options BYSCORE, BYLEX, REV and LIMIT of ZRANGE are not supported.
+func cmdZrange(c *conn, args [][]byte) bool {
+
+	scores, ok := withScores(args, 4)
+	if !ok {
+		c.wr.writeError("ERR syntax error")
+		return false
+	}
+
+	start, ok1 := parseInt(args[2])
+	stop, ok2 := parseInt(args[3])
+	if !ok1 || !ok2 {
+		c.writeErr(server.ErrNotInteger)
+		return false
+	}
+
+	rev := strings.ToUpper(string(args[0])) == "ZREVRANGE"
+
+	items, err := c.owner.srv.RangeByRank(string(args[1]), start, stop, rev)
+	if err != nil {
+		c.writeErr(err)
+	} else {
+		c.writeZsetItems(items, scores)
+	}
+
+	return false
+}
+
+// ZRANGEBYSCORE key min max [WITHSCORES]
+// ZREVRANGEBYSCORE key max min [WITHSCORES]
+// Option LIMIT is not supported.
+func cmdZrangebyscore(c *conn, args [][]byte) bool {
+
+	scores, ok := withScores(args, 4)
+	if !ok {
+		c.wr.writeError("ERR syntax error")
+		return false
+	}
+
+	min, ok1 := parseScore(args[2])
+	max, ok2 := parseScore(args[3])
+	if !ok1 || !ok2 {
+		c.wr.writeError("ERR min or max is not a float")
+		return false
+	}
+
+	rev := strings.ToUpper(string(args[0])) == "ZREVRANGEBYSCORE"
+	if rev {
+		min, max = max, min
+	}
+
+	items, err := c.owner.srv.RangeByScore(string(args[1]), min, max, rev)
+	if err != nil {
+		c.writeErr(err)
+	} else {
+		c.writeZsetItems(items, scores)
+	}
+
+	return false
+}
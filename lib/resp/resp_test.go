@@ -0,0 +1,144 @@
+package resp
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/server"
+	"github.com/iaroslavscript/cacheman/lib/simplecache"
+	"github.com/iaroslavscript/cacheman/lib/simplereplication"
+	"github.com/iaroslavscript/cacheman/lib/simplescheduler"
+)
+
+var testServer *RespServer
+var testServerOnce sync.Once
+
+// newTestServer returns the server shared by tests because metrics
+// can be registered only once
+func newTestServer() *RespServer {
+
+	testServerOnce.Do(func() {
+		cfg := *config.GetConfig()
+		srv := server.NewServer(&cfg,
+			simplecache.NewSimpleCache(),
+			simplereplication.NewSimpleReplication(&cfg),
+			simplescheduler.NewSimpleExpirer(&cfg),
+		)
+		testServer = NewRespServer(&cfg, srv)
+	})
+
+	return testServer
+}
+
+// newTestConn returns the client side of the connection served by
+// the test server and the channel closed when the server ends the connection
+func newTestConn(t *testing.T) (net.Conn, chan struct{}) {
+
+	s := newTestServer()
+	client, nc := net.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		s.handle(nc)
+		close(done)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { client.Close() })
+
+	return client, done
+}
+
+func roundtrip(t *testing.T, client net.Conn, request, wants string) {
+
+	t.Helper()
+
+	go client.Write([]byte(request))
+
+	reply := make([]byte, len(wants))
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("reply to %q error %s", request, err.Error())
+	}
+
+	if string(reply) != wants {
+		t.Errorf("reply to %q = %q; wants %q", request, reply, wants)
+	}
+}
+
+// waitBlocked waits until the server counts n blocked commands
+func waitBlocked(t *testing.T, s *RespServer, n int64) {
+
+	for i := 0; s.srv.Waiters() != n; i++ {
+		if i == 1000 {
+			t.Fatalf("the number of blocked commands isn't %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBpop(t *testing.T) {
+
+	s := newTestServer()
+	client, _ := newTestConn(t)
+
+	go client.Write([]byte("BLPOP bpop/list 0\r\n"))
+	waitBlocked(t, s, 1)
+
+	s.srv.Push("bpop/list", server.ListTail, [][]byte{[]byte("x")}, 0)
+
+	wants := "*2\r\n$9\r\nbpop/list\r\n$1\r\nx\r\n"
+	reply := make([]byte, len(wants))
+	if _, err := io.ReadFull(client, reply); err != nil || string(reply) != wants {
+		t.Errorf("BLPOP reply = %q, %v; wants %q", reply, err, wants)
+	}
+
+	// the connection is still usable after the blocking command
+	roundtrip(t, client, "BRPOP bpop/list 0.01\r\nPING\r\n", "$-1\r\n+PONG\r\n")
+}
+
+func TestBpopClosed(t *testing.T) {
+
+	s := newTestServer()
+	client, done := newTestConn(t)
+	s.srv.Delete("bpop/closed")
+
+	go client.Write([]byte("BLPOP bpop/closed 0\r\n"))
+	waitBlocked(t, s, 1)
+
+	client.Close()
+	waitBlocked(t, s, 0)
+	<-done
+
+	// the item pushed after the client is gone stays in the list
+	s.srv.Push("bpop/closed", server.ListTail, [][]byte{[]byte("x")}, 0)
+
+	if n, err := s.srv.Length("bpop/closed"); err != nil || n != 1 {
+		t.Errorf("Length() = %d, %v; wants 1", n, err)
+	}
+}
+
+func TestExpiresTooLarge(t *testing.T) {
+
+	client, _ := newTestConn(t)
+
+	table := []struct {
+		request string
+		wants   string
+	}{
+		{"SET large x EX 9223372036854775\r\n", "-ERR invalid expire time in 'set' command\r\n"},
+		{"SET large x PX 9223372036854775807\r\n", "-ERR invalid expire time in 'set' command\r\n"},
+		{"SET large x EX 60\r\n", "+OK\r\n"},
+		{"EXPIRE large 9223372036854775\r\n", "-ERR invalid expire time in 'expire' command\r\n"},
+		{"PEXPIRE large 9223372036854775807\r\n", "-ERR invalid expire time in 'pexpire' command\r\n"},
+		{"EXPIRE large -9223372036854775\r\n", ":1\r\n"},
+		{"EXISTS large\r\n", ":0\r\n"},
+	}
+
+	for _, x := range table {
+		roundtrip(t, client, x.request, x.wants)
+	}
+}
@@ -0,0 +1,24 @@
+package sdk
+
+// BackendItem is the change of the key written to the backend
+type BackendItem struct {
+	Key     string
+	Expires int64 // unix time in milliseconds
+	Flags   uint32
+	Value   []byte
+	Deleted bool // the key is deleted, other fields are zero
+}
+
+// Backend is the persistent store the cache writes to behind the clients.
+// Only plain values are written to the backend.
+type Backend interface {
+	// Write stores or deletes the keys of the batch. The batch is written
+	// again after an error so the write must be idempotent.
+	Write(items []BackendItem) error
+	// Flush deletes all keys of namespace or all keys at all
+	// if namespace is empty.
+	Flush(namespace string) error
+	// Load calls fn for every stored key
+	Load(fn func(item BackendItem)) error
+	Close() error
+}
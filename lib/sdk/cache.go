@@ -1,22 +1,68 @@
 package sdk
 
-import "sync/atomic"
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// NamespaceSeparator splits a key into namespace and the rest of the key.
+// The key "users/42" belongs to namespace "users".
+const NamespaceSeparator = "/"
+
+// Keys starting with ReservedKeyPrefix are reserved for service endpoints
+// like locks. They aren't available through operations on keys, written
+// to backends or notified to subscribers.
+const ReservedKeyPrefix = "_"
 
 type KeyInfo struct {
-	Expires int64
+	Expires int64 // unix time in milliseconds
 	Key     string
 }
 
 type Record struct {
 	recId   uint64
-	Expires int64
-	Value   []byte
+	Expires int64 // unix time in milliseconds
+	// StaleExpires is the hard expiration time. The record expired at Expires
+	// is kept until StaleExpires to be served stale, 0 means it isn't kept.
+	StaleExpires int64
+	Flags        uint32 // opaque to the server, used by memcached clients
+	Value        []byte
+	// Hash, list and sorted set values, nil for other values. Unlike plain
+	// values they're modified in place so they must be accessed only inside
+	// UpdateFunc.
+	Hash *Hash
+	List *List
+	Zset *SortedSet
 }
 
+// Results of UpdateFunc
+const (
+	UpdateKeep   int8 = iota // leave the stored record untouched
+	UpdateStore              // store the modified record
+	UpdateDelete             // delete the record
+)
+
+// UpdateFunc receives a copy of the stored record which could be modified
+// in place. The flag ok is false if the record is absent or expired at the
+// moment of KeyInfo.Expires, the record is zero in that case.
+// It's called under the cache lock so it must not call the cache.
+type UpdateFunc func(rec *Record, ok bool) int8
+
 type Cache interface {
 	Insert(key KeyInfo, rec Record)
 	Lookup(key KeyInfo) (Record, bool)
-	Delete(key KeyInfo)
+	// Delete removes the record expired at the moment of KeyInfo.Expires
+	// and reports whether it was removed
+	Delete(key KeyInfo) bool
+	// Update atomically reads, modifies and writes back the record
+	Update(key KeyInfo, fn UpdateFunc)
+	// Wait returns a channel which is closed when the key is stored or deleted
+	// next time.
+	// The cancel func should be called if the caller stops waiting before that.
+	Wait(key string) (ch <-chan struct{}, cancel func())
+	// Flush removes all records of namespace or all records at all
+	// if namespace is empty.
+	Flush(namespace string)
 }
 
 var (
@@ -33,6 +79,42 @@ func NewRecord(expires int64, value []byte) *Record {
 	}
 }
 
+// HardExpires returns the time the record should be deleted at
+func (rec *Record) HardExpires() int64 {
+
+	if rec.StaleExpires > rec.Expires {
+		return rec.StaleExpires
+	}
+
+	return rec.Expires
+}
+
+// IsHash reports whether the record holds a hash value
+func (rec *Record) IsHash() bool {
+	return rec.Hash != nil
+}
+
+// IsPlain reports whether the record holds a plain value
+// which is neither a hash, a list nor a sorted set
+func (rec *Record) IsPlain() bool {
+	return rec.Hash == nil && rec.List == nil && rec.Zset == nil
+}
+
+// IsZset reports whether the record holds a sorted set value
+func (rec *Record) IsZset() bool {
+	return rec.Zset != nil
+}
+
+// IsList reports whether the record holds a list value
+func (rec *Record) IsList() bool {
+	return rec.List != nil
+}
+
+// Renew assigns a new record id to the modified record
+func (rec *Record) Renew() {
+	rec.recId = atomic.AddUint64(&currRecId, 1)
+}
+
 func (rec *Record) GetRecId() uint64 {
 	return rec.recId
 }
@@ -40,3 +122,24 @@ func (rec *Record) GetRecId() uint64 {
 func LatestRecordId() uint64 {
 	return atomic.LoadUint64(&currRecId)
 }
+
+// Namespace returns the namespace of the key or an empty string if the key
+// doesn't belong to any namespace.
+func Namespace(key string) string {
+	if i := strings.Index(key, NamespaceSeparator); i > 0 {
+		return key[:i]
+	}
+
+	return ""
+}
+
+// IsReserved reports whether the key is reserved for service endpoints
+func IsReserved(key string) bool {
+	return strings.HasPrefix(key, ReservedKeyPrefix)
+}
+
+// InNamespace reports whether the key belongs to namespace. Every key belongs
+// to the empty namespace.
+func InNamespace(key string, namespace string) bool {
+	return namespace == "" || Namespace(key) == namespace
+}
@@ -0,0 +1,71 @@
+package sdk
+
+// Hash is a map of fields which keeps the total size of fields and values
+// so a change of one field costs O(1).
+// Hash is not safe for concurrent use.
+type Hash struct {
+	fields map[string][]byte
+	size   int64
+}
+
+func NewHash() *Hash {
+	return &Hash{fields: make(map[string][]byte)}
+}
+
+// Len returns the number of fields
+func (h *Hash) Len() int {
+	return len(h.fields)
+}
+
+// Size returns the size of fields and values in bytes
+func (h *Hash) Size() int64 {
+	return h.size
+}
+
+// Get returns the value of the field
+func (h *Hash) Get(field string) ([]byte, bool) {
+	value, ok := h.fields[field]
+	return value, ok
+}
+
+// SizeAfterSet returns the size the hash would have after Set
+func (h *Hash) SizeAfterSet(field string, value []byte) int64 {
+
+	size := h.size + int64(len(value))
+	if cur, ok := h.fields[field]; ok {
+		size -= int64(len(cur))
+	} else {
+		size += int64(len(field))
+	}
+
+	return size
+}
+
+// Set stores the value of the field
+func (h *Hash) Set(field string, value []byte) {
+	h.size = h.SizeAfterSet(field, value)
+	h.fields[field] = value
+}
+
+// Delete removes the field and reports whether it existed
+func (h *Hash) Delete(field string) bool {
+
+	value, ok := h.fields[field]
+	if ok {
+		h.size -= int64(len(field) + len(value))
+		delete(h.fields, field)
+	}
+
+	return ok
+}
+
+// Fields returns a copy of all fields
+func (h *Hash) Fields() map[string][]byte {
+
+	fields := make(map[string][]byte, len(h.fields))
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+
+	return fields
+}
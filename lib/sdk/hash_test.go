@@ -0,0 +1,41 @@
+package sdk
+
+import (
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+
+	h := NewHash()
+
+	h.Set("a", []byte("xyz"))
+	h.Set("bc", []byte("x"))
+
+	if h.Len() != 2 || h.Size() != 7 {
+		t.Errorf("Len(), Size() = %d, %d; wants 2, 7", h.Len(), h.Size())
+	}
+
+	if x := h.SizeAfterSet("a", []byte("x")); x != 5 {
+		t.Errorf("SizeAfterSet() of existing field = %d; wants 5", x)
+	}
+
+	if x := h.SizeAfterSet("d", []byte("x")); x != 9 {
+		t.Errorf("SizeAfterSet() of new field = %d; wants 9", x)
+	}
+
+	h.Set("a", []byte("x"))
+	if value, ok := h.Get("a"); !ok || string(value) != "x" || h.Size() != 5 {
+		t.Errorf("Get() of changed field = %q, %v with size %d; wants x with size 5", value, ok, h.Size())
+	}
+
+	if !h.Delete("bc") || h.Delete("bc") {
+		t.Errorf("Delete() doesn't report the existing field only")
+	}
+
+	fields := h.Fields()
+	fields["e"] = nil
+
+	if h.Len() != 1 || h.Size() != 2 {
+		t.Errorf("Len(), Size() = %d, %d; wants 1, 2", h.Len(), h.Size())
+	}
+}
@@ -0,0 +1,158 @@
+package sdk
+
+// The capacity of the list allocated by the first push
+const listMinCapacity = 8
+
+// List is a sequence of items pushed and popped at both ends in O(1).
+// It's a ring buffer which grows twice when it's full and shrinks twice
+// when it's less than a quarter full.
+// List is not safe for concurrent use.
+type List struct {
+	items  [][]byte
+	head   int // the position of the first item in items
+	length int
+	size   int64
+}
+
+func NewList() *List {
+	return &List{}
+}
+
+// Len returns the number of items
+func (l *List) Len() int {
+	return l.length
+}
+
+// Size returns the size of items in bytes
+func (l *List) Size() int64 {
+	return l.size
+}
+
+// at returns the position in items of the item with index i
+func (l *List) at(i int) int {
+	return (l.head + i) % len(l.items)
+}
+
+// resize moves the items to the buffer of the given capacity
+func (l *List) resize(capacity int) {
+
+	items := make([][]byte, capacity)
+	for i := 0; i < l.length; i++ {
+		items[i] = l.items[l.at(i)]
+	}
+
+	l.items = items
+	l.head = 0
+}
+
+func (l *List) grow() {
+
+	if l.length < len(l.items) {
+		return
+	}
+
+	capacity := 2 * len(l.items)
+	if capacity < listMinCapacity {
+		capacity = listMinCapacity
+	}
+
+	l.resize(capacity)
+}
+
+func (l *List) shrink() {
+
+	capacity := len(l.items)
+	for capacity > listMinCapacity && l.length < capacity/4 {
+		capacity /= 2
+	}
+
+	if capacity < len(l.items) {
+		l.resize(capacity)
+	}
+}
+
+// PushHead adds the value before the first item
+func (l *List) PushHead(value []byte) {
+
+	l.grow()
+	l.head = (l.head + len(l.items) - 1) % len(l.items)
+	l.items[l.head] = value
+	l.length++
+	l.size += int64(len(value))
+}
+
+// PushTail adds the value after the last item
+func (l *List) PushTail(value []byte) {
+
+	l.grow()
+	l.items[l.at(l.length)] = value
+	l.length++
+	l.size += int64(len(value))
+}
+
+// PopHead removes and returns the first item
+func (l *List) PopHead() ([]byte, bool) {
+
+	if l.length == 0 {
+		return nil, false
+	}
+
+	value := l.items[l.head]
+	l.items[l.head] = nil
+	l.head = (l.head + 1) % len(l.items)
+	l.length--
+	l.size -= int64(len(value))
+	l.shrink()
+
+	return value, true
+}
+
+// PopTail removes and returns the last item
+func (l *List) PopTail() ([]byte, bool) {
+
+	if l.length == 0 {
+		return nil, false
+	}
+
+	i := l.at(l.length - 1)
+	value := l.items[i]
+	l.items[i] = nil
+	l.length--
+	l.size -= int64(len(value))
+	l.shrink()
+
+	return value, true
+}
+
+// Range returns the items with indexes from start up to but not including
+// stop. The indexes should be within the list.
+func (l *List) Range(start, stop int) [][]byte {
+
+	result := make([][]byte, 0, stop-start)
+	for i := start; i < stop; i++ {
+		result = append(result, l.items[l.at(i)])
+	}
+
+	return result
+}
+
+// Trim keeps only the items with indexes from start up to but not including
+// stop. The indexes should be within the list.
+func (l *List) Trim(start, stop int) {
+
+	if l.length == 0 {
+		return
+	}
+
+	for i := 0; i < l.length; i++ {
+		if i < start || i >= stop {
+			j := l.at(i)
+			l.size -= int64(len(l.items[j]))
+			l.items[j] = nil
+		}
+	}
+
+	l.head = l.at(start)
+	l.length = stop - start
+	l.shrink()
+}
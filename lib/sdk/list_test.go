@@ -0,0 +1,106 @@
+package sdk
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// checkList compares the list with the slice of wanted items
+func checkList(t *testing.T, l *List, wants [][]byte) {
+
+	t.Helper()
+
+	if l.Len() != len(wants) {
+		t.Fatalf("Len() = %d; wants %d", l.Len(), len(wants))
+	}
+
+	var size int64
+	for _, x := range wants {
+		size += int64(len(x))
+	}
+
+	if l.Size() != size {
+		t.Fatalf("Size() = %d; wants %d", l.Size(), size)
+	}
+
+	got := l.Range(0, l.Len())
+	for i := range wants {
+		if string(got[i]) != string(wants[i]) {
+			t.Fatalf("Range()[%d] = %q; wants %q", i, got[i], wants[i])
+		}
+	}
+}
+
+func TestListRandom(t *testing.T) {
+
+	l := NewList()
+	var wants [][]byte
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 5000; i++ {
+		value := []byte(strconv.Itoa(i))
+
+		switch rnd.Intn(5) {
+		case 0:
+			l.PushHead(value)
+			wants = append([][]byte{value}, wants...)
+		case 1:
+			l.PushTail(value)
+			wants = append(wants, value)
+		case 2:
+			got, ok := l.PopHead()
+			if ok != (len(wants) > 0) {
+				t.Fatalf("PopHead() ok = %v; wants %v", ok, !ok)
+			}
+
+			if ok {
+				if string(got) != string(wants[0]) {
+					t.Fatalf("PopHead() = %q; wants %q", got, wants[0])
+				}
+				wants = wants[1:]
+			}
+		case 3:
+			got, ok := l.PopTail()
+			if ok != (len(wants) > 0) {
+				t.Fatalf("PopTail() ok = %v; wants %v", ok, !ok)
+			}
+
+			if ok {
+				if string(got) != string(wants[len(wants)-1]) {
+					t.Fatalf("PopTail() = %q; wants %q", got, wants[len(wants)-1])
+				}
+				wants = wants[:len(wants)-1]
+			}
+		case 4:
+			if rnd.Intn(20) != 0 || len(wants) == 0 {
+				continue
+			}
+
+			start := rnd.Intn(len(wants))
+			stop := start + rnd.Intn(len(wants)-start+1)
+			l.Trim(start, stop)
+			wants = append([][]byte(nil), wants[start:stop]...)
+		}
+
+		checkList(t, l, wants)
+	}
+}
+
+func TestListShrink(t *testing.T) {
+
+	l := NewList()
+	for i := 0; i < 1000; i++ {
+		l.PushTail([]byte("x"))
+	}
+
+	for i := 0; i < 995; i++ {
+		l.PopHead()
+	}
+
+	if len(l.items) > 4*listMinCapacity {
+		t.Errorf("capacity of the list of %d items is %d", l.Len(), len(l.items))
+	}
+
+	checkList(t, l, [][]byte{[]byte("x"), []byte("x"), []byte("x"), []byte("x"), []byte("x")})
+}
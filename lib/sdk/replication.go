@@ -1,5 +1,41 @@
 package sdk
 
+// Actions of replication log items
+const (
+	ReplActionInsert int8 = iota
+	ReplActionDelete
+	// Key.Key of the flush marker holds the flushed namespace,
+	// empty namespace means the whole cache.
+	ReplActionFlush
+	// Expired records are deleted on every node by its own scheduler so
+	// expire items are never written to the replication log. They are used
+	// only to notify about changes.
+	ReplActionExpire
+	// Key.Key of the publish item holds the pub/sub channel and Value holds
+	// the message. Publish items don't change the cache, replicas only
+	// deliver them to subscribers of the channel.
+	ReplActionPublish
+	// Field-level changes of hash values. Field holds the changed field,
+	// Value.Value the new value of the field and Key.Expires the expiration
+	// time of the hash. The hash is deleted when its last field is deleted.
+	ReplActionHashSet
+	ReplActionHashDelete
+	// Changes of list values. Value.Value holds the pushed or popped item
+	// and Key.Expires the expiration time of the list. The list is deleted
+	// when its last item is popped or trimmed.
+	ReplActionListPushHead
+	ReplActionListPushTail
+	ReplActionListPopHead
+	ReplActionListPopTail
+	// Start and Stop hold the range of items kept by the trim
+	ReplActionListTrim
+	// Changes of sorted set values. Field holds the member, Score its new
+	// score and Key.Expires the expiration time of the sorted set. The sorted
+	// set is deleted when its last member is removed.
+	ReplActionZsetAdd
+	ReplActionZsetRemove
+)
+
 type LogInfo struct {
 	Id   int64
 	Time int64
@@ -8,6 +44,10 @@ type LogInfo struct {
 type ReplItem struct {
 	Action int8
 	Key    KeyInfo
+	Field  string  // the hash field or the sorted set member
+	Start  int64   // the first item kept by list trim
+	Stop   int64   // the last item kept by list trim
+	Score  float64 // the score of sorted set actions
 	Value  Record
 }
 
@@ -20,6 +60,15 @@ type Replication interface {
 	Add(item ReplItem)
 }
 
+// MultiReplication writes every item to all of the replications in order
+type MultiReplication []Replication
+
+func (m MultiReplication) Add(item ReplItem) {
+	for _, x := range m {
+		x.Add(item)
+	}
+}
+
 // TODO remove unnessasery copy of []bytes here
 func NewReplItem(action int8, key KeyInfo, value Record) *ReplItem {
 	return &ReplItem{
@@ -28,3 +77,17 @@ func NewReplItem(action int8, key KeyInfo, value Record) *ReplItem {
 		Value:  value,
 	}
 }
+
+// HasValue reports whether Value.Value of the item holds the value set,
+// pushed or popped by the change
+func (item *ReplItem) HasValue() bool {
+
+	switch item.Action {
+	case ReplActionInsert, ReplActionHashSet,
+		ReplActionListPushHead, ReplActionListPushTail,
+		ReplActionListPopHead, ReplActionListPopTail:
+		return true
+	}
+
+	return false
+}
@@ -1,6 +1,15 @@
 package sdk
 
 type Scheduler interface {
+	// Add schedules the record. The record of the key scheduled already
+	// is moved to the new time.
 	Add(key KeyInfo)
-	GetChan() *chan KeyInfo
+	// Cancel removes the scheduled record of the key expiring not later
+	// than key.Expires.
+	Cancel(key KeyInfo)
+	// GetChan returns the channel of batches of expired records
+	GetChan() *chan []KeyInfo
+	// Flush cancels all scheduled records of namespace or all scheduled
+	// records at all if namespace is empty.
+	Flush(namespace string)
 }
@@ -0,0 +1,280 @@
+package sdk
+
+import (
+	"math/rand"
+)
+
+const sortedSetMaxLevel = 32
+
+// The probability of a node to have one more level
+const sortedSetLevelP = 0.25
+
+type SortedSetItem struct {
+	Member string
+	Score  float64
+}
+
+// less orders items by score and members with the same score lexicographically
+func (a *SortedSetItem) less(b *SortedSetItem) bool {
+	return a.Score < b.Score || (a.Score == b.Score && a.Member < b.Member)
+}
+
+type sortedSetLevel struct {
+	next *sortedSetNode
+	span int // the number of nodes between the node and next
+}
+
+type sortedSetNode struct {
+	item   SortedSetItem
+	levels []sortedSetLevel
+}
+
+// SortedSet is a set of unique members ordered by score. It's a skip list
+// with spans for rank lookups in O(log n) plus a map of scores by member.
+// SortedSet is not safe for concurrent use.
+type SortedSet struct {
+	head   *sortedSetNode
+	level  int
+	length int
+	scores map[string]float64
+	size   int64
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		head:   &sortedSetNode{levels: make([]sortedSetLevel, sortedSetMaxLevel)},
+		level:  1,
+		scores: make(map[string]float64),
+	}
+}
+
+func randomSortedSetLevel() int {
+
+	level := 1
+	for level < sortedSetMaxLevel && rand.Float64() < sortedSetLevelP {
+		level++
+	}
+
+	return level
+}
+
+// Len returns the number of members
+func (z *SortedSet) Len() int {
+	return z.length
+}
+
+// Size returns the size of members and scores in bytes
+func (z *SortedSet) Size() int64 {
+	return z.size
+}
+
+// Score returns the score of the member
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, ok := z.scores[member]
+	return score, ok
+}
+
+// Add sets the score of the member and reports whether the member is new
+func (z *SortedSet) Add(member string, score float64) bool {
+
+	old, ok := z.scores[member]
+	if ok {
+		if old == score {
+			return false
+		}
+
+		z.delete(SortedSetItem{Member: member, Score: old})
+	} else {
+		z.size += int64(len(member)) + 8
+	}
+
+	z.scores[member] = score
+	z.insert(SortedSetItem{Member: member, Score: score})
+
+	return !ok
+}
+
+// Remove deletes the member and reports whether it existed
+func (z *SortedSet) Remove(member string) bool {
+
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+
+	delete(z.scores, member)
+	z.size -= int64(len(member)) + 8
+	z.delete(SortedSetItem{Member: member, Score: score})
+
+	return true
+}
+
+func (z *SortedSet) insert(item SortedSetItem) {
+
+	var update [sortedSetMaxLevel]*sortedSetNode
+	var rank [sortedSetMaxLevel]int
+
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		if i < z.level-1 {
+			rank[i] = rank[i+1]
+		}
+
+		for x.levels[i].next != nil && x.levels[i].next.item.less(&item) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].next
+		}
+
+		update[i] = x
+	}
+
+	level := randomSortedSetLevel()
+	if level > z.level {
+		for i := z.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = z.head
+			update[i].levels[i].span = z.length
+		}
+
+		z.level = level
+	}
+
+	node := &sortedSetNode{item: item, levels: make([]sortedSetLevel, level)}
+	for i := 0; i < level; i++ {
+		node.levels[i].next = update[i].levels[i].next
+		update[i].levels[i].next = node
+
+		node.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+
+	// the new node is under the higher levels
+	for i := level; i < z.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	z.length++
+}
+
+func (z *SortedSet) delete(item SortedSetItem) {
+
+	var update [sortedSetMaxLevel]*sortedSetNode
+
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.item.less(&item) {
+			x = x.levels[i].next
+		}
+
+		update[i] = x
+	}
+
+	node := x.levels[0].next
+	if node == nil || node.item != item {
+		return
+	}
+
+	for i := 0; i < z.level; i++ {
+		if update[i].levels[i].next == node {
+			update[i].levels[i].span += node.levels[i].span - 1
+			update[i].levels[i].next = node.levels[i].next
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+
+	for z.level > 1 && z.head.levels[z.level-1].next == nil {
+		z.level--
+	}
+
+	z.length--
+}
+
+// Rank returns the 0-based position of the member in the order of scores
+func (z *SortedSet) Rank(member string) (int, bool) {
+
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false
+	}
+
+	item := SortedSetItem{Member: member, Score: score}
+	rank := 0
+
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && !item.less(&x.levels[i].next.item) {
+			rank += x.levels[i].span
+			x = x.levels[i].next
+		}
+
+		if x.item == item && x != z.head {
+			return rank - 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// nodeByRank returns the node at the 0-based position
+func (z *SortedSet) nodeByRank(rank int) *sortedSetNode {
+
+	traversed := 0
+	rank++
+
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && traversed+x.levels[i].span <= rank {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+
+		if traversed == rank {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// RangeByRank returns the items between 0-based positions start and stop
+// inclusive. Positions out of the set are ignored.
+func (z *SortedSet) RangeByRank(start, stop int) []SortedSetItem {
+
+	if start < 0 {
+		start = 0
+	}
+
+	if stop >= z.length {
+		stop = z.length - 1
+	}
+
+	if start > stop {
+		return nil
+	}
+
+	result := make([]SortedSetItem, 0, stop-start+1)
+	for x := z.nodeByRank(start); x != nil && len(result) < cap(result); x = x.levels[0].next {
+		result = append(result, x.item)
+	}
+
+	return result
+}
+
+// RangeByScore returns the items with scores between min and max inclusive
+func (z *SortedSet) RangeByScore(min, max float64) []SortedSetItem {
+
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.item.Score < min {
+			x = x.levels[i].next
+		}
+	}
+
+	var result []SortedSetItem
+	for x = x.levels[0].next; x != nil && x.item.Score <= max; x = x.levels[0].next {
+		result = append(result, x.item)
+	}
+
+	return result
+}
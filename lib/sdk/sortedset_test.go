@@ -0,0 +1,105 @@
+package sdk
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// sortedItems returns the members of the map ordered the same way
+// as SortedSet does
+func sortedItems(scores map[string]float64) []SortedSetItem {
+
+	result := make([]SortedSetItem, 0, len(scores))
+	for member, score := range scores {
+		result = append(result, SortedSetItem{Member: member, Score: score})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].less(&result[j])
+	})
+
+	return result
+}
+
+func TestSortedSetRandom(t *testing.T) {
+
+	z := NewSortedSet()
+	wants := make(map[string]float64)
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 5000; i++ {
+		member := fmt.Sprintf("m%d", rnd.Intn(500))
+		score := float64(rnd.Intn(50))
+
+		if rnd.Intn(4) == 0 {
+			_, ok := wants[member]
+			if z.Remove(member) != ok {
+				t.Fatalf("Remove(%s) = %v; wants %v", member, !ok, ok)
+			}
+			delete(wants, member)
+			continue
+		}
+
+		_, ok := wants[member]
+		if z.Add(member, score) == ok {
+			t.Fatalf("Add(%s) = %v; wants %v", member, ok, !ok)
+		}
+		wants[member] = score
+	}
+
+	items := sortedItems(wants)
+	if z.Len() != len(items) {
+		t.Fatalf("Len() = %d; wants %d", z.Len(), len(items))
+	}
+
+	for i, x := range items {
+		if rank, ok := z.Rank(x.Member); !ok || rank != i {
+			t.Errorf("Rank(%s) = %d, %v; wants %d", x.Member, rank, ok, i)
+		}
+	}
+
+	got := z.RangeByRank(10, 19)
+	for i, x := range got {
+		if x != items[10+i] {
+			t.Errorf("RangeByRank() item %d = %v; wants %v", i, x, items[10+i])
+		}
+	}
+
+	if len(got) != 10 {
+		t.Errorf("RangeByRank() returned %d items; wants 10", len(got))
+	}
+
+	var byScore []SortedSetItem
+	for _, x := range items {
+		if x.Score >= 10 && x.Score <= 12 {
+			byScore = append(byScore, x)
+		}
+	}
+
+	got = z.RangeByScore(10, 12)
+	if fmt.Sprint(got) != fmt.Sprint(byScore) {
+		t.Errorf("RangeByScore() = %v; wants %v", got, byScore)
+	}
+}
+
+func TestSortedSetEmpty(t *testing.T) {
+
+	z := NewSortedSet()
+
+	if _, ok := z.Rank("a"); ok {
+		t.Errorf("Rank() of absent member found")
+	}
+
+	if got := z.RangeByRank(0, -1); len(got) != 0 {
+		t.Errorf("RangeByRank() = %v; wants empty", got)
+	}
+
+	z.Add("a", 1)
+	z.Remove("a")
+
+	if z.Len() != 0 || z.Size() != 0 {
+		t.Errorf("Len(), Size() = %d, %d; wants 0, 0", z.Len(), z.Size())
+	}
+}
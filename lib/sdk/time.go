@@ -0,0 +1,15 @@
+package sdk
+
+import "time"
+
+// Expiration times are unix times in milliseconds
+
+// NowMs returns the current unix time in milliseconds
+func NowMs() int64 {
+	return UnixMs(time.Now())
+}
+
+// UnixMs returns t as a unix time in milliseconds
+func UnixMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
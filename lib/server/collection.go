@@ -0,0 +1,113 @@
+package server
+
+import (
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// collectionLen returns the number of fields, items or members of the hash,
+// list or sorted set held by the record
+func collectionLen(rec *sdk.Record) int {
+
+	switch {
+	case rec.Hash != nil:
+		return rec.Hash.Len()
+	case rec.List != nil:
+		return rec.List.Len()
+	case rec.Zset != nil:
+		return rec.Zset.Len()
+	}
+
+	return 0
+}
+
+// updateCollection calls fn to modify the hash, list or sorted set of the key
+// in place under the cache lock and replicates the items returned by fn.
+// fn checks the type of the stored record and sets a new empty value to
+// the absent one. The items carry only the changed field, item or member,
+// not the whole value. Absent value is stored only if fn returns any items.
+// The value is deleted if it becomes empty, then the deletion is replicated
+// and the expiration is canceled too.
+func (s *Server) updateCollection(key string, expiresInMs int64,
+	fn func(rec *sdk.Record, ok bool) ([]*sdk.ReplItem, error)) error {
+
+	if err := s.validateKey(key); err != nil {
+		return err
+	}
+
+	if expiresInMs < 0 {
+		return ErrExpiresInvalid
+	} else if expiresInMs > MaxExpiresInMs {
+		return ErrExpiresTooLarge
+	}
+
+	now := sdk.NowMs()
+	var items []*sdk.ReplItem
+	var err error
+	rescheduled := false
+	stored := false
+	deleted := false
+	var expiresBefore int64
+
+	(*s.cache).Update(sdk.KeyInfo{Expires: now, Key: key},
+		func(rec *sdk.Record, ok bool) int8 {
+
+			var changes []*sdk.ReplItem
+			if changes, err = fn(rec, ok); err != nil || len(changes) == 0 {
+				return sdk.UpdateKeep
+			}
+
+			expires := rec.Expires
+			if expiresInMs > 0 {
+				expires = now + expiresInMs
+			} else if !ok {
+				expires = now + s.cfg.ExpiresDefaultDurationSec*1000
+			}
+
+			rescheduled = !ok || expires != rec.Expires
+			expiresBefore = rec.HardExpires()
+
+			*rec = sdk.Record{
+				Expires: expires,
+				Hash:    rec.Hash,
+				List:    rec.List,
+				Zset:    rec.Zset,
+			}
+			rec.Renew()
+
+			for _, item := range changes {
+				value := item.Value.Value
+				item.Key = sdk.KeyInfo{Expires: expires, Key: key}
+				item.Value = *rec
+				item.Value.Hash = nil
+				item.Value.List = nil
+				item.Value.Zset = nil
+				item.Value.Value = value
+			}
+
+			items = changes
+
+			if collectionLen(rec) == 0 {
+				deleted = ok
+				return sdk.UpdateDelete
+			}
+
+			stored = true
+			return sdk.UpdateStore
+		})
+
+	for _, item := range items {
+		s.replicateItem(item)
+	}
+
+	if stored && rescheduled {
+		(*s.sched).Add(sdk.KeyInfo{Expires: items[0].Key.Expires, Key: key})
+	}
+
+	// the emptied value is deleted the same way as by Delete
+	if deleted {
+		s.replicateDelete(key)
+		(*s.sched).Cancel(sdk.KeyInfo{Expires: expiresBefore, Key: key})
+	}
+
+	return err
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+func TestCollectionEmptied(t *testing.T) {
+
+	s, repl := newTestServer()
+
+	table := []struct {
+		key   string
+		fill  func(key string)
+		empty func(key string)
+	}{
+		{"emptied/hash",
+			func(key string) { s.SetField(key, "a", []byte("x"), 0) },
+			func(key string) { s.DeleteField(key, "a") }},
+		{"emptied/list",
+			func(key string) { s.Push(key, ListTail, [][]byte{[]byte("x")}, 0) },
+			func(key string) { s.Pop(key, ListHead) }},
+		{"emptied/zset",
+			func(key string) { s.AddScores(key, []sdk.SortedSetItem{{Member: "a", Score: 1}}, 0) },
+			func(key string) { s.RemoveMembers(key, []string{"a"}) }},
+	}
+
+	for _, x := range table {
+		x.fill(x.key)
+		rec, _ := (*s.cache).Lookup(sdk.KeyInfo{Expires: sdk.NowMs(), Key: x.key})
+
+		testServerSched.m.Lock()
+		canceled := len(testServerSched.canceled)
+		testServerSched.m.Unlock()
+		replicated := len(repl.items)
+
+		x.empty(x.key)
+
+		if s.Exists(x.key) {
+			t.Errorf("%s exists after removing the last element", x.key)
+		}
+
+		items := repl.items[replicated:]
+		if len(items) != 2 || items[1].Action != sdk.ReplActionDelete || items[1].Key.Key != x.key {
+			t.Errorf("%s replicated %v; wants the change and the delete", x.key, items)
+		}
+
+		testServerSched.m.Lock()
+		got := testServerSched.canceled[canceled:]
+		testServerSched.m.Unlock()
+
+		if len(got) != 1 || got[0] != (sdk.KeyInfo{Expires: rec.HardExpires(), Key: x.key}) {
+			t.Errorf("%s canceled %v; wants the expiration %d", x.key, got, rec.HardExpires())
+		}
+	}
+}
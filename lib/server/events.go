@@ -0,0 +1,219 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Subscription receives changes of one key or of keys starting with
+// the prefix. The changes are the same items that are written to
+// the replication log plus expire items. Flush markers are delivered to every
+// subscription which could match keys of the flushed namespace.
+// If the subscriber doesn't keep up with the changes then depending on
+// watch_slow_policy either the subscription is dropped and C is closed or
+// the changes are lost and counted by Lost.
+type Subscription struct {
+	lost    uint64 // first to be 64-bit aligned for atomic operations
+	C       chan sdk.ReplItem
+	dropped int32
+	exact   bool
+	hub     *eventHub
+	prefix  string
+}
+
+type eventHub struct {
+	bufferSize     int64
+	disconnectSlow bool
+	m              sync.RWMutex
+	subs           map[*Subscription]bool
+
+	opsSubscribers     prometheus.Gauge
+	opsDroppedTotal    prometheus.Counter
+	opsLostEventsTotal prometheus.Counter
+}
+
+// newEventHub creates a hub which exports its metrics with the name prefix
+func newEventHub(cfg *config.Config, name string) *eventHub {
+
+	h := &eventHub{
+		bufferSize:     cfg.WatchBufferSize,
+		disconnectSlow: cfg.WatchSlowPolicy == config.WatchPolicyDisconnect,
+		subs:           make(map[*Subscription]bool),
+
+		opsSubscribers: promauto.NewGauge(prometheus.GaugeOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      name + "_subscribers",
+			Help:      fmt.Sprintf("The number of active %s subscriptions", name),
+		}),
+
+		opsDroppedTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      name + "_dropped_subscribers_total",
+			Help:      fmt.Sprintf("The total number of %s subscriptions dropped because of slow subscribers", name),
+		}),
+
+		opsLostEventsTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      name + "_lost_events_total",
+			Help:      fmt.Sprintf("The total number of %s events not delivered to slow subscribers", name),
+		}),
+	}
+
+	h.opsSubscribers.Set(0.0)
+	h.opsDroppedTotal.Add(0.0)
+	h.opsLostEventsTotal.Add(0.0)
+
+	return h
+}
+
+func (h *eventHub) subscribe(prefix string, exact bool) *Subscription {
+
+	sub := &Subscription{
+		C:      make(chan sdk.ReplItem, h.bufferSize),
+		exact:  exact,
+		hub:    h,
+		prefix: prefix,
+	}
+
+	h.m.Lock()
+	h.subs[sub] = true
+	h.m.Unlock()
+
+	h.opsSubscribers.Inc()
+
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *Subscription) {
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.subs[sub] {
+		delete(h.subs, sub)
+		close(sub.C)
+		h.opsSubscribers.Dec()
+	}
+}
+
+// publish returns the number of subscriptions the item is queued for.
+// Changes of reserved keys like locks are never published.
+func (h *eventHub) publish(item sdk.ReplItem) int {
+
+	if sdk.IsReserved(item.Key.Key) {
+		return 0
+	}
+
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	n := 0
+	for sub := range h.subs {
+		if !sub.match(item) || atomic.LoadInt32(&sub.dropped) == 1 {
+			continue
+		}
+
+		select {
+		case sub.C <- item:
+			n++
+		default:
+			if !h.disconnectSlow {
+				atomic.AddUint64(&sub.lost, 1)
+				h.opsLostEventsTotal.Inc()
+				continue
+			}
+
+			// the channel can't be closed under the read lock
+			// because other publishers could be sending to it
+			if atomic.CompareAndSwapInt32(&sub.dropped, 0, 1) {
+				h.opsDroppedTotal.Inc()
+				go h.unsubscribe(sub)
+			}
+		}
+	}
+
+	return n
+}
+
+func (sub *Subscription) match(item sdk.ReplItem) bool {
+
+	if item.Action == sdk.ReplActionFlush {
+		namespace := item.Key.Key
+		if namespace == "" || sub.exact {
+			return sdk.InNamespace(sub.prefix, namespace)
+		}
+
+		// the prefix could match keys of the namespace
+		namespace += sdk.NamespaceSeparator
+		return strings.HasPrefix(namespace, sub.prefix) || strings.HasPrefix(sub.prefix, namespace)
+	}
+
+	if sub.exact {
+		return item.Key.Key == sub.prefix
+	}
+
+	return strings.HasPrefix(item.Key.Key, sub.prefix)
+}
+
+// Dropped reports whether the subscription was closed because
+// the subscriber was too slow
+func (sub *Subscription) Dropped() bool {
+	return atomic.LoadInt32(&sub.dropped) == 1
+}
+
+// Lost returns the number of changes lost since the previous call
+func (sub *Subscription) Lost() uint64 {
+	return atomic.SwapUint64(&sub.lost, 0)
+}
+
+// Close stops the subscription and closes C
+func (sub *Subscription) Close() {
+	sub.hub.unsubscribe(sub)
+}
+
+// Subscribe returns a subscription to changes of keys starting with prefix.
+// Empty prefix subscribes to all keys except reserved ones.
+func (s *Server) Subscribe(prefix string) (*Subscription, error) {
+
+	if sdk.IsReserved(prefix) {
+		return nil, ErrKeyReserved
+	}
+
+	return s.events.subscribe(prefix, false), nil
+}
+
+// SubscribeKey returns a subscription to changes of the key
+func (s *Server) SubscribeKey(key string) (*Subscription, error) {
+
+	if sdk.IsReserved(key) {
+		return nil, ErrKeyReserved
+	}
+
+	return s.events.subscribe(key, true), nil
+}
+
+// Subscribers returns the number of active subscriptions
+func (s *Server) Subscribers() int {
+
+	s.events.m.RLock()
+	defer s.events.m.RUnlock()
+
+	return len(s.events.subs)
+}
+
+// NotifyExpired notifies subscribers about the record deleted by
+// the scheduler. It should be passed to sdk.Cache watching the scheduler.
+func (s *Server) NotifyExpired(key sdk.KeyInfo) {
+	s.events.publish(*sdk.NewReplItem(sdk.ReplActionExpire, key, sdk.Record{}))
+}
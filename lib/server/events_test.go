@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+func TestSubscriptionMatch(t *testing.T) {
+
+	item := func(action int8, key string) sdk.ReplItem {
+		return sdk.ReplItem{Action: action, Key: sdk.KeyInfo{Key: key}}
+	}
+
+	table := []struct {
+		sub   Subscription
+		item  sdk.ReplItem
+		wants bool
+	}{
+		{Subscription{prefix: "a/"}, item(sdk.ReplActionInsert, "a/1"), true},
+		{Subscription{prefix: "a/"}, item(sdk.ReplActionDelete, "b/1"), false},
+		{Subscription{prefix: "a/1", exact: true}, item(sdk.ReplActionExpire, "a/10"), false},
+		{Subscription{prefix: "a/1", exact: true}, item(sdk.ReplActionInsert, "a/1"), true},
+		{Subscription{prefix: "a/"}, item(sdk.ReplActionFlush, ""), true},
+		{Subscription{prefix: "a/"}, item(sdk.ReplActionFlush, "b"), false},
+		{Subscription{prefix: "a/x"}, item(sdk.ReplActionFlush, "a"), true},
+		{Subscription{prefix: "a"}, item(sdk.ReplActionFlush, "ab"), true},
+		{Subscription{prefix: "a/1", exact: true}, item(sdk.ReplActionFlush, "b"), false},
+	}
+
+	for i, x := range table {
+		if got := x.sub.match(x.item); got != x.wants {
+			t.Errorf("case %d: match() = %v; wants %v", i, got, x.wants)
+		}
+	}
+}
+
+func TestSubscribeReserved(t *testing.T) {
+
+	s, _ := newTestServer()
+
+	sub, err := s.Subscribe("")
+	if err != nil {
+		t.Fatalf("Subscribe() error %s", err.Error())
+	}
+	defer sub.Close()
+
+	// the lock and the fill token are stored as reserved keys
+	if _, err = s.AcquireLock("watched", "token", 60000); err != nil {
+		t.Fatalf("AcquireLock() error %s", err.Error())
+	}
+	defer s.ReleaseLock("watched", "token")
+
+	if _, _, token, _ := s.LookupOrFill(context.Background(), "watched/fill", time.Millisecond); token == "" {
+		t.Fatalf("LookupOrFill() of absent key returns no fill token")
+	}
+
+	s.Set("watched/a", []byte("x"), 0, SetAlways)
+
+	if item := <-sub.C; item.Key.Key != "watched/a" {
+		t.Errorf("subscriber received %s; wants watched/a", item.Key.Key)
+	}
+
+	if _, err = s.Subscribe(lockKeyPrefix); err != ErrKeyReserved {
+		t.Errorf("Subscribe(%s) error %v; wants %v", lockKeyPrefix, err, ErrKeyReserved)
+	}
+
+	if _, err = s.SubscribeKey(lockKeyPrefix + "watched"); err != ErrKeyReserved {
+		t.Errorf("SubscribeKey(%s) error %v; wants %v", lockKeyPrefix+"watched", err, ErrKeyReserved)
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// Fill locks are stored as keys of the reserved namespace like locks.
+// The value of a fill lock is the token of the client populating the key.
+// The lock expires after fill_lock_ttl_sec if the client never populates it.
+const fillKeyPrefix = "_fill/"
+
+var (
+	ErrFillTokenStale = errors.New("Fill token has expired and the key is populated by another client")
+	ErrStaleInvalid   = errors.New("Improper value of X-Content-Stale-Sec http header")
+)
+
+// LookupOrFill returns the value of the key. The first caller missing
+// the key gets the token allowing it to populate the key by Fill together
+// with the stale copy of the key if it's kept. Other callers get the stale
+// copy or wait for the value up to wait.
+func (s *Server) LookupOrFill(ctx context.Context, key string,
+	wait time.Duration) (sdk.Record, bool, string, error) {
+
+	if rec, ok := s.Get(key); ok {
+		return rec, true, "", nil
+	}
+
+	stale, hasStale := s.getStale(key, sdk.NowMs())
+
+	if token, ok := s.acquireFill(key); ok {
+		return stale, hasStale, token, nil
+	}
+
+	if hasStale {
+		return stale, true, "", nil
+	}
+
+	rec, ok, err := s.waitRecord(ctx, key, "", wait)
+	return rec, ok, "", err
+}
+
+// acquireFill returns the token of the new fill lock of the key
+// if the key isn't populated by another client
+func (s *Server) acquireFill(key string) (string, bool) {
+
+	token := NewLockToken()
+	acquired := false
+
+	s.fillMu.Lock()
+	defer s.fillMu.Unlock()
+
+	s.update(fillKeyPrefix+key, func(rec *sdk.Record, ok bool) int8 {
+
+		if ok {
+			return sdk.UpdateKeep
+		}
+
+		*rec = sdk.Record{
+			Expires: sdk.NowMs() + s.cfg.FillLockTtlSec*1000,
+			Value:   []byte(token),
+		}
+
+		acquired = true
+		return sdk.UpdateStore
+	}, true)
+
+	return token, acquired
+}
+
+// Fill stores the value and releases the fill lock of the key held by token.
+// The value is kept staleMs after its expiration time to be served stale
+// while the key is populated next time. The default expiration time is used
+// if expiresInMs is 0. The expired token is accepted unless another client
+// has got a new token to populate the key, the value isn't stored then.
+// The token is checked and the value is stored under fillMu so no token
+// is given to another client in between.
+func (s *Server) Fill(key string, value []byte, expiresInMs, staleMs int64, token string) error {
+
+	if err := s.validateKey(key); err != nil {
+		return err
+	}
+
+	if expiresInMs == 0 {
+		expiresInMs = s.cfg.ExpiresDefaultDurationSec * 1000
+	} else if expiresInMs < 0 {
+		return ErrExpiresInvalid
+	} else if expiresInMs > MaxExpiresInMs {
+		return ErrExpiresTooLarge
+	}
+
+	if staleMs < 0 || staleMs > MaxExpiresInMs {
+		return ErrStaleInvalid
+	}
+
+	if token != "" {
+		s.fillMu.Lock()
+		defer s.fillMu.Unlock()
+
+		rec, ok := (*s.cache).Lookup(sdk.KeyInfo{Expires: sdk.NowMs(), Key: fillKeyPrefix + key})
+		if ok && string(rec.Value) != token {
+			return ErrFillTokenStale
+		}
+	}
+
+	err := s.update(key, func(rec *sdk.Record, ok bool) int8 {
+
+		*rec = sdk.Record{
+			Expires: sdk.NowMs() + expiresInMs,
+			Value:   value,
+		}
+
+		if staleMs > 0 {
+			rec.StaleExpires = rec.Expires + staleMs
+		}
+
+		return sdk.UpdateStore
+	}, true)
+
+	if err != nil || token == "" {
+		return err
+	}
+
+	return s.update(fillKeyPrefix+key, func(rec *sdk.Record, ok bool) int8 {
+
+		if ok && string(rec.Value) == token {
+			return sdk.UpdateDelete
+		}
+
+		return sdk.UpdateKeep
+	}, false)
+}
+
+// parseHeaderStale returns the number of seconds in header X-Content-Stale-Sec
+// in milliseconds or 0 if it's absent
+func parseHeaderStale(r *http.Request) (int64, error) {
+
+	val := r.Header.Get("X-Content-Stale-Sec")
+	if val == "" {
+		return 0, nil
+	}
+
+	sec, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || sec < 0 || sec > MaxExpiresInMs/1000 {
+		return 0, ErrStaleInvalid
+	}
+
+	return sec * 1000, nil
+}
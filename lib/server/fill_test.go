@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+func TestFill(t *testing.T) {
+
+	s, _ := newTestServer()
+	ctx := context.Background()
+
+	_, ok, token, err := s.LookupOrFill(ctx, "fill", time.Millisecond)
+	if err != nil || ok || token == "" {
+		t.Fatalf("LookupOrFill() of absent key = %v, %q, %v; wants the fill token", ok, token, err)
+	}
+
+	if _, ok, other, _ := s.LookupOrFill(ctx, "fill", time.Millisecond); ok || other != "" {
+		t.Errorf("LookupOrFill() while filled = %v, %q; wants miss without token", ok, other)
+	}
+
+	// the lock is held but it's hidden from operations on keys
+	if _, ok := (*s.cache).Lookup(sdk.KeyInfo{Expires: sdk.NowMs(), Key: fillKeyPrefix + "fill"}); !ok {
+		t.Fatalf("fill lock is not stored")
+	}
+
+	if s.Exists(fillKeyPrefix + "fill") {
+		t.Errorf("fill lock is available through operations on keys")
+	}
+
+	if err = s.Fill("fill", []byte("x"), 0, 60000, token); err != nil {
+		t.Fatalf("Fill() error %s", err.Error())
+	}
+
+	if _, ok := (*s.cache).Lookup(sdk.KeyInfo{Expires: sdk.NowMs(), Key: fillKeyPrefix + "fill"}); ok {
+		t.Errorf("fill lock is held after Fill()")
+	}
+
+	now := sdk.NowMs()
+	(*s.cache).Insert(sdk.KeyInfo{Expires: now - 1000, Key: "fill"},
+		sdk.Record{Expires: now - 1000, StaleExpires: now + 60000, Value: []byte("stale")})
+
+	rec, ok, token, _ := s.LookupOrFill(ctx, "fill", time.Millisecond)
+	if !ok || !IsStale(rec, now) || token == "" {
+		t.Errorf("LookupOrFill() of expired key = %q, %v, %q; wants stale value and the fill token",
+			rec.Value, ok, token)
+	}
+
+	rec, ok, token, _ = s.LookupOrFill(ctx, "fill", time.Millisecond)
+	if !ok || string(rec.Value) != "stale" || token != "" {
+		t.Errorf("LookupOrFill() of expired key while filled = %q, %v, %q; wants stale value",
+			rec.Value, ok, token)
+	}
+}
+
+func TestFillStaleToken(t *testing.T) {
+
+	s, _ := newTestServer()
+	ctx := context.Background()
+
+	_, _, token, _ := s.LookupOrFill(ctx, "fill/stale", time.Millisecond)
+
+	// the lock expires and another client gets a new token
+	s.update(fillKeyPrefix+"fill/stale", func(rec *sdk.Record, ok bool) int8 {
+		rec.Expires = sdk.NowMs() - 1
+		return sdk.UpdateStore
+	}, true)
+
+	_, _, other, _ := s.LookupOrFill(ctx, "fill/stale", time.Millisecond)
+	if other == "" || other == token {
+		t.Fatalf("LookupOrFill() after the lock expired = %q; wants a new token", other)
+	}
+
+	if err := s.Fill("fill/stale", []byte("old"), 0, 0, token); err != ErrFillTokenStale {
+		t.Errorf("Fill() with the expired token error %v; wants %v", err, ErrFillTokenStale)
+	}
+
+	if s.Exists("fill/stale") {
+		t.Errorf("Fill() with the expired token stored the value")
+	}
+
+	if err := s.Fill("fill/stale", []byte("new"), 0, 0, other); err != nil {
+		t.Errorf("Fill() with the new token error %s", err.Error())
+	}
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// Hash values are maps of fields stored under one key. Every field is
+// modified atomically without rewriting the other fields by the client
+// and the change is replicated as a field-level item.
+const hashPath = "/_hash/"
+
+var ErrFieldEmpty = errors.New("Field should not be empty")
+
+func pathToHash(path string) string {
+	return strings.TrimPrefix(path, hashPath)
+}
+
+// GetField returns the value of the field of the hash
+func (s *Server) GetField(key, field string) ([]byte, bool, error) {
+
+	var value []byte
+	found := false
+
+	err := s.viewHash(key, func(h *sdk.Hash) {
+		value, found = h.Get(field)
+	})
+
+	return value, found, err
+}
+
+// GetFields returns all fields of the hash
+func (s *Server) GetFields(key string) (map[string][]byte, bool, error) {
+
+	var fields map[string][]byte
+	found := false
+
+	err := s.viewHash(key, func(h *sdk.Hash) {
+		fields = h.Fields()
+		found = true
+	})
+
+	return fields, found, err
+}
+
+// SetField sets the field of the hash and reports whether the field is new.
+// Absent hash is created with expiration time expiresInMs or the default
+// one if expiresInMs is 0. Otherwise 0 keeps the expiration time of the hash.
+func (s *Server) SetField(key, field string, value []byte, expiresInMs int64) (bool, error) {
+
+	created := false
+	err := s.updateField(key, field, expiresInMs, func(cur []byte, ok bool) ([]byte, int8) {
+		created = !ok
+		return value, sdk.UpdateStore
+	})
+
+	return created && err == nil, err
+}
+
+// DeleteField removes the field of the hash and reports whether it existed.
+// The hash is deleted together with its last field.
+func (s *Server) DeleteField(key, field string) (bool, error) {
+
+	found := false
+	err := s.updateField(key, field, 0, func(cur []byte, ok bool) ([]byte, int8) {
+		found = ok
+		return nil, sdk.UpdateDelete
+	})
+
+	return found && err == nil, err
+}
+
+// IncrField adds delta to the integer value of the field and returns
+// the result. Absent field is created with value 0.
+func (s *Server) IncrField(key, field string, delta int64) (int64, error) {
+
+	var result int64
+	var err error
+
+	e := s.updateField(key, field, 0, func(cur []byte, ok bool) ([]byte, int8) {
+
+		if result, err = incrValue(cur, ok, delta); err != nil {
+			return nil, sdk.UpdateKeep
+		}
+
+		return []byte(strconv.FormatInt(result, 10)), sdk.UpdateStore
+	})
+
+	if e != nil {
+		return 0, e
+	}
+
+	return result, err
+}
+
+// viewHash calls fn with the hash of the key under the cache lock.
+// fn isn't called if the key is absent.
+func (s *Server) viewHash(key string, fn func(h *sdk.Hash)) error {
+
+	if sdk.IsReserved(key) {
+		return nil
+	}
+
+	var err error
+
+	(*s.cache).Update(sdk.KeyInfo{Expires: sdk.NowMs(), Key: key},
+		func(rec *sdk.Record, ok bool) int8 {
+
+			if ok && !rec.IsHash() {
+				err = ErrWrongType
+			} else if ok {
+				fn(rec.Hash)
+			}
+
+			return sdk.UpdateKeep
+		})
+
+	return err
+}
+
+// updateField atomically modifies one field of the hash in place by fn
+// and updateCollection. Only the change of the field is replicated.
+func (s *Server) updateField(key, field string, expiresInMs int64,
+	fn func(value []byte, ok bool) ([]byte, int8)) error {
+
+	if field == "" {
+		return ErrFieldEmpty
+	}
+
+	return s.updateCollection(key, expiresInMs, func(rec *sdk.Record, ok bool) ([]*sdk.ReplItem, error) {
+
+		if !ok {
+			rec.Hash = sdk.NewHash()
+		} else if !rec.IsHash() {
+			return nil, ErrWrongType
+		}
+
+		h := rec.Hash
+		value, found := h.Get(field)
+		value, action := fn(value, found)
+
+		switch {
+		case action == sdk.UpdateStore:
+			if h.SizeAfterSet(field, value) > s.cfg.MaxValueBytes {
+				return nil, ErrValueTooLarge
+			}
+
+			h.Set(field, value)
+			return []*sdk.ReplItem{{Action: sdk.ReplActionHashSet, Field: field, Value: sdk.Record{Value: value}}}, nil
+
+		case action == sdk.UpdateDelete && found:
+			h.Delete(field)
+			return []*sdk.ReplItem{{Action: sdk.ReplActionHashDelete, Field: field}}, nil
+		}
+
+		return nil, nil
+	})
+}
+
+func writeHashError(t time.Time, w http.ResponseWriter, r *http.Request, err error) {
+
+	code := http.StatusBadRequest
+	if err == ErrWrongType {
+		code = http.StatusConflict
+	}
+
+	w.WriteHeader(code)
+	w.Write([]byte(err.Error()))
+	log.Printf(requestInfo(t, code, r, "error:%s", err.Error()))
+}
+
+// Lookup for the field by GET /_hash/somekey?field=somefield or for all
+// fields of the hash as JSON object of base64 encoded values
+// by GET /_hash/somekey
+func (s *Server) getFieldHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	key := pathToHash(r.URL.Path)
+	query := r.URL.Query()
+
+	var body []byte
+	var ok bool
+	var err error
+
+	if query["field"] != nil {
+		body, ok, err = s.GetField(key, query.Get("field"))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		var fields map[string][]byte
+		if fields, ok, err = s.GetFields(key); ok {
+			body, err = json.Marshal(fields)
+		}
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	if err != nil {
+		writeHashError(t, w, r, err)
+		return
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+		log.Printf(requestInfo(t, http.StatusNotFound, r, ""))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	log.Printf(requestInfo(t, http.StatusOK, r, "value_size:%d", len(body)))
+}
+
+// Set the field to the body by PUT /_hash/somekey?field=somefield or
+// increment the integer value of the field by
+// POST /_hash/somekey?field=somefield&incr=1
+func (s *Server) setFieldHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	key := pathToHash(r.URL.Path)
+	query := r.URL.Query()
+	field := query.Get("field")
+
+	if incr := query.Get("incr"); incr != "" {
+		delta, err := strconv.ParseInt(incr, 10, 64)
+		if err != nil {
+			writeHashError(t, w, r, ErrNotInteger)
+			return
+		}
+
+		result, err := s.IncrField(key, field, delta)
+		if err != nil {
+			writeHashError(t, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(strconv.FormatInt(result, 10)))
+		log.Printf(requestInfo(t, http.StatusOK, r, "field:%s", field))
+		return
+	}
+
+	// the expiration time of existed hash is kept without the header
+	var expiresInMs int64
+	var err error
+
+	if hasHeaderContentExpires(r) {
+		if expiresInMs, err = s.parseHeaderContentExpires(r); err != nil {
+			writeHashError(t, w, r, err)
+			return
+		}
+	}
+
+	value, ok := s.readValue(t, w, r)
+	if !ok {
+		return
+	}
+
+	if _, err = s.SetField(key, field, value, expiresInMs); err != nil {
+		writeHashError(t, w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Printf(requestInfo(t, http.StatusOK, r, "field:%s", field))
+}
+
+// Delete the field by DELETE /_hash/somekey?field=somefield or the whole
+// hash by DELETE /_hash/somekey
+func (s *Server) deleteFieldHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	key := pathToHash(r.URL.Path)
+	query := r.URL.Query()
+
+	if query["field"] == nil {
+		s.Delete(key)
+	} else if _, err := s.DeleteField(key, query.Get("field")); err != nil {
+		writeHashError(t, w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Printf(requestInfo(t, http.StatusOK, r, ""))
+}
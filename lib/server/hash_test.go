@@ -0,0 +1,90 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+func TestHashFields(t *testing.T) {
+
+	s, repl := newTestServer()
+	replicated := len(repl.items)
+
+	if created, err := s.SetField("h", "a", []byte("x"), 0); err != nil || !created {
+		t.Fatalf("SetField() = %v, %v; wants true, nil", created, err)
+	}
+
+	if n, err := s.IncrField("h", "n", 5); err != nil || n != 5 {
+		t.Errorf("IncrField() = %d, %v; wants 5, nil", n, err)
+	}
+
+	if _, err := s.IncrField("h", "a", 1); err != ErrNotInteger {
+		t.Errorf("IncrField() of not integer = %v; wants %v", err, ErrNotInteger)
+	}
+
+	fields, ok, err := s.GetFields("h")
+	if err != nil || !ok || len(fields) != 2 || string(fields["a"]) != "x" || string(fields["n"]) != "5" {
+		t.Errorf("GetFields() = %q, %v, %v; wants a=x n=5", fields, ok, err)
+	}
+
+	for _, field := range []string{"a", "n"} {
+		if found, err := s.DeleteField("h", field); err != nil || !found {
+			t.Errorf("DeleteField(%s) = %v, %v; wants true, nil", field, found, err)
+		}
+	}
+
+	if s.Exists("h") {
+		t.Errorf("hash exists after deleting the last field")
+	}
+
+	actions := []int8{sdk.ReplActionHashSet, sdk.ReplActionHashSet, sdk.ReplActionHashDelete, sdk.ReplActionHashDelete,
+		sdk.ReplActionDelete}
+	items := repl.items[replicated:]
+	if len(items) != len(actions) {
+		t.Fatalf("replicated %d items; wants %d", len(items), len(actions))
+	}
+
+	for i, x := range actions {
+		if items[i].Action != x || items[i].Value.Hash != nil {
+			t.Errorf("item %d = %v; wants field-level action %d", i, items[i], x)
+		}
+	}
+
+	if string(items[1].Value.Value) != "5" || items[1].Field != "n" {
+		t.Errorf("item 1 = %s %q; wants n \"5\"", items[1].Field, items[1].Value.Value)
+	}
+
+	s.Set("plain", []byte("1"), 0, SetAlways)
+	if _, err := s.SetField("plain", "a", []byte("x"), 0); err != ErrWrongType {
+		t.Errorf("SetField() of plain value = %v; wants %v", err, ErrWrongType)
+	}
+
+	s.SetField("h", "a", []byte("x"), 0)
+	if _, err := s.Incr("h", 1); err != ErrWrongType {
+		t.Errorf("Incr() of hash = %v; wants %v", err, ErrWrongType)
+	}
+}
+
+func TestHashSize(t *testing.T) {
+
+	s, _ := newTestServer()
+
+	s.SetField("hsize", "a", []byte("x"), 0)
+	large := make([]byte, s.cfg.MaxValueBytes)
+	if _, err := s.SetField("hsize", "b", large, 0); err != ErrValueTooLarge {
+		t.Errorf("SetField() of too large value = %v; wants %v", err, ErrValueTooLarge)
+	}
+
+	// replacing the value frees its size
+	limit := large[:s.cfg.MaxValueBytes-int64(len("a")+len("b")+1)]
+	s.SetField("hsize", "b", []byte("xyz"), 0)
+	s.SetField("hsize", "b", []byte("x"), 0)
+	if _, err := s.SetField("hsize", "b", limit, 0); err != nil {
+		t.Errorf("SetField() of value fitting max_value_bytes error %v", err)
+	}
+
+	if fields, _, _ := s.GetFields("hsize"); len(fields) != 2 || len(fields["b"]) != len(limit) {
+		t.Errorf("GetFields() = %d fields; wants a and b", len(fields))
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"math/rand"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// jitter extends the number of milliseconds the key expires in by a random
+// duration according to the first entry of expires_jitter matching the key,
+// so keys set at once with the same duration don't expire at once
+func (s *Server) jitter(key string, expiresInMs int64) int64 {
+
+	for _, x := range s.cfg.ExpiresJitter {
+		if !sdk.InNamespace(key, x.Namespace) {
+			continue
+		}
+
+		spread := expiresInMs * x.Percent / 100
+		if spread < x.Ms {
+			spread = x.Ms
+		}
+
+		if spread > 0 {
+			expiresInMs += rand.Int63n(spread + 1)
+		}
+
+		return expiresInMs
+	}
+
+	return expiresInMs
+}
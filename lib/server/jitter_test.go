@@ -0,0 +1,46 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+)
+
+func TestJitter(t *testing.T) {
+
+	s, _ := newTestServer()
+	s.cfg.ExpiresJitter = []config.Jitter{
+		{Namespace: "pct", Percent: 10},
+		{Namespace: "abs", Percent: 5, Ms: 5000},
+		{Namespace: "none"},
+		{Namespace: "", Ms: 100},
+	}
+	defer func() { s.cfg.ExpiresJitter = nil }()
+
+	table := []struct {
+		key    string
+		min    int64
+		max    int64
+		spread bool
+	}{
+		{"pct/a", 60000, 66000, true},
+		{"abs/a", 60000, 65000, true},
+		{"none/a", 60000, 60000, false},
+		{"other/a", 60000, 60100, true},
+	}
+
+	for _, x := range table {
+		seen := make(map[int64]bool)
+		for i := 0; i < 100; i++ {
+			expires := s.jitter(x.key, 60000)
+			if expires < x.min || expires > x.max {
+				t.Fatalf("jitter(%s, 60000) = %d; wants between %d and %d", x.key, expires, x.min, x.max)
+			}
+			seen[expires] = true
+		}
+
+		if spread := len(seen) > 1; spread != x.spread {
+			t.Errorf("jitter(%s, 60000) returns %d distinct durations; wants spread %v", x.key, len(seen), x.spread)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// List values are sequences of items which are pushed and popped at both
+// ends. Every push and pop is replicated as a separate item so a list
+// works as a work queue shared by replicas.
+const listPath = "/_list/"
+
+// Ends of a list
+const (
+	ListHead int8 = iota
+	ListTail
+)
+
+var ErrListSide = errors.New("Side should be either head or tail")
+
+var listSides = map[string]int8{
+	"head": ListHead,
+	"tail": ListTail,
+}
+
+var pushActions = map[int8]int8{
+	ListHead: sdk.ReplActionListPushHead,
+	ListTail: sdk.ReplActionListPushTail,
+}
+
+var popActions = map[int8]int8{
+	ListHead: sdk.ReplActionListPopHead,
+	ListTail: sdk.ReplActionListPopTail,
+}
+
+func pathToList(path string) string {
+	return strings.TrimPrefix(path, listPath)
+}
+
+// listRange converts the range given by indexes counted from the head or,
+// if negative, from the tail to the range of the slice of n items.
+// The range is empty if start >= stop.
+func listRange(n, start, stop int64) (int64, int64) {
+
+	if start < 0 {
+		start += n
+	}
+
+	if stop < 0 {
+		stop += n
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if stop >= n {
+		stop = n - 1
+	}
+
+	if start > stop {
+		return 0, 0
+	}
+
+	return start, stop + 1
+}
+
+// Push adds the values to the side of the list in the given order and
+// returns the length of the list. Absent list is created with expiration
+// time expiresInMs or the default one if expiresInMs is 0. Otherwise 0
+// keeps the expiration time of the list.
+func (s *Server) Push(key string, side int8, values [][]byte, expiresInMs int64) (int64, error) {
+
+	action, ok := pushActions[side]
+	if !ok {
+		return 0, ErrListSide
+	}
+
+	var n int64
+	err := s.updateList(key, expiresInMs, func(l *sdk.List) ([]*sdk.ReplItem, error) {
+
+		// check the size before modifying the list in place
+		size := l.Size()
+		for _, value := range values {
+			size += int64(len(value))
+		}
+
+		if size > s.cfg.MaxValueBytes {
+			return nil, ErrValueTooLarge
+		}
+
+		items := make([]*sdk.ReplItem, 0, len(values))
+		for _, value := range values {
+			if side == ListHead {
+				l.PushHead(value)
+			} else {
+				l.PushTail(value)
+			}
+
+			items = append(items, &sdk.ReplItem{Action: action, Value: sdk.Record{Value: value}})
+		}
+
+		n = int64(l.Len())
+		return items, nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Pop removes the item from the side of the list and returns it
+func (s *Server) Pop(key string, side int8) ([]byte, bool, error) {
+
+	action, ok := popActions[side]
+	if !ok {
+		return nil, false, ErrListSide
+	}
+
+	var value []byte
+	found := false
+
+	err := s.updateList(key, 0, func(l *sdk.List) ([]*sdk.ReplItem, error) {
+
+		if side == ListHead {
+			value, found = l.PopHead()
+		} else {
+			value, found = l.PopTail()
+		}
+
+		if !found {
+			return nil, nil
+		}
+
+		return []*sdk.ReplItem{{Action: action, Value: sdk.Record{Value: value}}}, nil
+	})
+
+	return value, found, err
+}
+
+// BlockingPop is Pop waiting for the item until the wait time elapses
+// or ctx is done
+func (s *Server) BlockingPop(ctx context.Context, key string, side int8,
+	wait time.Duration) ([]byte, bool, error) {
+
+	var value []byte
+	var found bool
+	var err error
+
+	e := s.waitFor(ctx, key, wait, func() bool {
+		value, found, err = s.Pop(key, side)
+		return found || err != nil
+	})
+
+	if e != nil {
+		return nil, false, e
+	}
+
+	return value, found, err
+}
+
+// Range returns the items of the list between start and stop inclusive.
+// Negative indexes are counted from the tail, -1 is the last item.
+func (s *Server) Range(key string, start, stop int64) ([][]byte, bool, error) {
+
+	var result [][]byte
+	found := false
+
+	err := s.viewList(key, func(l *sdk.List) {
+		begin, end := listRange(int64(l.Len()), start, stop)
+		result = l.Range(int(begin), int(end))
+		found = true
+	})
+
+	return result, found, err
+}
+
+// Length returns the number of items of the list
+func (s *Server) Length(key string) (int64, error) {
+
+	var n int64
+	err := s.viewList(key, func(l *sdk.List) {
+		n = int64(l.Len())
+	})
+
+	return n, err
+}
+
+// Trim keeps only the items of the list between start and stop inclusive
+// the same way as Range
+func (s *Server) Trim(key string, start, stop int64) error {
+
+	return s.updateList(key, 0, func(l *sdk.List) ([]*sdk.ReplItem, error) {
+
+		if l.Len() == 0 {
+			return nil, nil
+		}
+
+		begin, end := listRange(int64(l.Len()), start, stop)
+		if begin == 0 && end == int64(l.Len()) {
+			return nil, nil
+		}
+
+		l.Trim(int(begin), int(end))
+
+		// replicas trim the same list so the range is sent as is
+		return []*sdk.ReplItem{{Action: sdk.ReplActionListTrim, Start: start, Stop: stop}}, nil
+	})
+}
+
+// viewList calls fn with the list of the key under the cache lock.
+// fn isn't called if the key is absent.
+func (s *Server) viewList(key string, fn func(l *sdk.List)) error {
+
+	if sdk.IsReserved(key) {
+		return nil
+	}
+
+	var err error
+
+	(*s.cache).Update(sdk.KeyInfo{Expires: sdk.NowMs(), Key: key},
+		func(rec *sdk.Record, ok bool) int8 {
+
+			if ok && !rec.IsList() {
+				err = ErrWrongType
+			} else if ok {
+				fn(rec.List)
+			}
+
+			return sdk.UpdateKeep
+		})
+
+	return err
+}
+
+// updateList calls fn to modify the list of the key in place by
+// updateCollection. Only the pushed or popped items are replicated.
+func (s *Server) updateList(key string, expiresInMs int64,
+	fn func(l *sdk.List) ([]*sdk.ReplItem, error)) error {
+
+	return s.updateCollection(key, expiresInMs, func(rec *sdk.Record, ok bool) ([]*sdk.ReplItem, error) {
+
+		if !ok {
+			rec.List = sdk.NewList()
+		} else if !rec.IsList() {
+			return nil, ErrWrongType
+		}
+
+		return fn(rec.List)
+	})
+}
+
+func writeListError(t time.Time, w http.ResponseWriter, r *http.Request, err error) {
+
+	code := http.StatusBadRequest
+	switch err {
+	case ErrWrongType:
+		code = http.StatusConflict
+	case ErrTooManyWaiters:
+		code = http.StatusServiceUnavailable
+	case ErrValueTooLarge:
+		code = http.StatusRequestEntityTooLarge
+	}
+
+	w.WriteHeader(code)
+	w.Write([]byte(err.Error()))
+	log.Printf(requestInfo(t, code, r, "error:%s", err.Error()))
+}
+
+// parseRange parses start and stop query parameters, the whole list
+// by default
+func parseRange(r *http.Request) (int64, int64, error) {
+
+	query := r.URL.Query()
+	bounds := []int64{0, -1}
+
+	for i, name := range []string{"start", "stop"} {
+		if val := query.Get(name); val != "" {
+			x, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return 0, 0, ErrNotInteger
+			}
+
+			bounds[i] = x
+		}
+	}
+
+	return bounds[0], bounds[1], nil
+}
+
+// Get the items of the list as JSON array of base64 encoded values
+// by GET /_list/somekey?start=0&stop=-1
+func (s *Server) rangeHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	start, stop, err := parseRange(r)
+	if err != nil {
+		writeListError(t, w, r, err)
+		return
+	}
+
+	items, ok, err := s.Range(pathToList(r.URL.Path), start, stop)
+	if err != nil {
+		writeListError(t, w, r, err)
+		return
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+		log.Printf(requestInfo(t, http.StatusNotFound, r, ""))
+		return
+	}
+
+	body, _ := json.Marshal(items)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	log.Printf(requestInfo(t, http.StatusOK, r, "items:%d", len(items)))
+}
+
+// Modify the list by POST /_list/somekey with one of parameters
+// push=head|tail to push the body, pop=head|tail to pop the item
+// or trim to keep only the items between start and stop
+func (s *Server) listHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+
+	switch {
+	case query["push"] != nil:
+		s.pushHandler(t, w, r, query.Get("push"))
+	case query["pop"] != nil:
+		s.popHandler(t, w, r, query.Get("pop"))
+	case query["trim"] != nil:
+		s.trimHandler(t, w, r)
+	default:
+		writeListError(t, w, r, errors.New("One of parameters push, pop or trim is required"))
+	}
+}
+
+// parseSide returns the side of the list, def if the value is empty
+func parseSide(val string, def int8) (int8, error) {
+
+	if val == "" {
+		return def, nil
+	}
+
+	side, ok := listSides[val]
+	if !ok {
+		return 0, ErrListSide
+	}
+
+	return side, nil
+}
+
+func (s *Server) pushHandler(t time.Time, w http.ResponseWriter, r *http.Request, val string) {
+
+	side, err := parseSide(val, ListTail)
+	if err != nil {
+		writeListError(t, w, r, err)
+		return
+	}
+
+	// the expiration time of existed list is kept without the header
+	var expiresInMs int64
+
+	if hasHeaderContentExpires(r) {
+		if expiresInMs, err = s.parseHeaderContentExpires(r); err != nil {
+			writeListError(t, w, r, err)
+			return
+		}
+	}
+
+	value, ok := s.readValue(t, w, r)
+	if !ok {
+		return
+	}
+
+	n, err := s.Push(pathToList(r.URL.Path), side, [][]byte{value}, expiresInMs)
+	if err != nil {
+		writeListError(t, w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(strconv.FormatInt(n, 10)))
+	log.Printf(requestInfo(t, http.StatusOK, r, "length:%d", n))
+}
+
+func (s *Server) popHandler(t time.Time, w http.ResponseWriter, r *http.Request, val string) {
+
+	side, err := parseSide(val, ListHead)
+	if err != nil {
+		writeListError(t, w, r, err)
+		return
+	}
+
+	wait, err := s.parseWait(r)
+	if err != nil {
+		writeListError(t, w, r, err)
+		return
+	}
+
+	key := pathToList(r.URL.Path)
+	var value []byte
+	var ok bool
+
+	if wait > 0 {
+		value, ok, err = s.BlockingPop(r.Context(), key, side, wait)
+	} else {
+		value, ok, err = s.Pop(key, side)
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		log.Printf(requestInfo(t, statusClientClosedRequest, r, "error:%s", err.Error()))
+		return
+	} else if err != nil {
+		writeListError(t, w, r, err)
+		return
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+		log.Printf(requestInfo(t, http.StatusNotFound, r, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(value)
+	log.Printf(requestInfo(t, http.StatusOK, r, "value_size:%d", len(value)))
+}
+
+func (s *Server) trimHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	start, stop, err := parseRange(r)
+	if err == nil {
+		err = s.Trim(pathToList(r.URL.Path), start, stop)
+	}
+
+	if err != nil {
+		writeListError(t, w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Printf(requestInfo(t, http.StatusOK, r, "start:%d stop:%d", start, stop))
+}
+
+// Delete the whole list by DELETE /_list/somekey
+func (s *Server) deleteListHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	s.Delete(pathToList(r.URL.Path))
+	w.WriteHeader(http.StatusOK)
+	log.Printf(requestInfo(t, http.StatusOK, r, ""))
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+func TestListRange(t *testing.T) {
+
+	table := []struct {
+		n, start, stop int64
+		begin, end     int64
+	}{
+		{5, 0, -1, 0, 5},
+		{5, 1, 2, 1, 3},
+		{5, -2, -1, 3, 5},
+		{5, -10, 10, 0, 5},
+		{5, 3, 1, 0, 0},
+		{5, 7, 9, 0, 0},
+		{0, 0, -1, 0, 0},
+	}
+
+	for i, x := range table {
+		begin, end := listRange(x.n, x.start, x.stop)
+		if begin != x.begin || end != x.end {
+			t.Errorf("case %d: listRange() = %d, %d; wants %d, %d", i, begin, end, x.begin, x.end)
+		}
+	}
+}
+
+func TestListQueue(t *testing.T) {
+
+	s, repl := newTestServer()
+	replicated := len(repl.items)
+
+	values := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	if n, err := s.Push("q", ListTail, values, 0); err != nil || n != 3 {
+		t.Fatalf("Push() = %d, %v; wants 3, nil", n, err)
+	}
+
+	if n, err := s.Push("q", ListHead, [][]byte{[]byte("y"), []byte("z")}, 0); err != nil || n != 5 {
+		t.Fatalf("Push() = %d, %v; wants 5, nil", n, err)
+	}
+
+	items, ok, err := s.Range("q", 0, -1)
+	if got := string(bytes.Join(items, nil)); err != nil || !ok || got != "zyabc" {
+		t.Errorf("Range() = %s, %v, %v; wants zyabc", got, ok, err)
+	}
+
+	if err = s.Trim("q", 1, -2); err != nil {
+		t.Fatalf("Trim() error %s", err.Error())
+	}
+
+	for _, x := range []struct {
+		side  int8
+		value string
+	}{{ListHead, "y"}, {ListTail, "b"}, {ListHead, "a"}} {
+		value, ok, err := s.Pop("q", x.side)
+		if err != nil || !ok || string(value) != x.value {
+			t.Errorf("Pop(%d) = %s, %v, %v; wants %s", x.side, value, ok, err, x.value)
+		}
+	}
+
+	if s.Exists("q") {
+		t.Errorf("list exists after popping the last item")
+	}
+
+	if _, ok, _ = s.BlockingPop(context.Background(), "q", ListHead, 10*time.Millisecond); ok {
+		t.Errorf("BlockingPop() of empty list found item")
+	}
+
+	actions := []int8{
+		sdk.ReplActionListPushTail, sdk.ReplActionListPushTail, sdk.ReplActionListPushTail,
+		sdk.ReplActionListPushHead, sdk.ReplActionListPushHead,
+		sdk.ReplActionListTrim,
+		sdk.ReplActionListPopHead, sdk.ReplActionListPopTail, sdk.ReplActionListPopHead,
+		sdk.ReplActionDelete,
+	}
+
+	got := repl.items[replicated:]
+	if len(got) != len(actions) {
+		t.Fatalf("replicated %d items; wants %d", len(got), len(actions))
+	}
+
+	for i, x := range actions {
+		if got[i].Action != x || got[i].Value.List != nil {
+			t.Errorf("item %d = %v; wants action %d", i, got[i], x)
+		}
+	}
+
+	s.Set("plain", []byte("1"), 0, SetAlways)
+	if _, err = s.Push("plain", ListTail, values, 0); err != ErrWrongType {
+		t.Errorf("Push() to plain value = %v; wants %v", err, ErrWrongType)
+	}
+
+	// the list is left untouched if pushed values don't fit max_value_bytes
+	s.Push("big", ListTail, values, 0)
+	large := [][]byte{make([]byte, s.cfg.MaxValueBytes)}
+	if _, err = s.Push("big", ListHead, large, 0); err != ErrValueTooLarge {
+		t.Errorf("Push() of too large value = %v; wants %v", err, ErrValueTooLarge)
+	}
+
+	if n, _ := s.Length("big"); n != 3 {
+		t.Errorf("Length() after failed push = %d; wants 3", n)
+	}
+}
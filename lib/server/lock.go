@@ -0,0 +1,282 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// Locks are stored as keys of the reserved namespace so they are replicated
+// and expired by the scheduler like any other key but are not available
+// through the key API. The value of a lock is the owner token.
+const lockPath = "/_lock/"
+const lockKeyPrefix = "_lock/"
+
+var (
+	ErrLockHeld     = errors.New("Lock is held by another owner")
+	ErrLockNotFound = errors.New("Lock is not held")
+	ErrLockNotOwned = errors.New("Lock token doesn't match")
+)
+
+func pathToLock(path string) string {
+	return strings.TrimPrefix(path, lockPath)
+}
+
+// NewLockToken returns a random owner token. It panics if the system
+// random source fails because a predictable token would let other clients
+// take over the lock.
+func NewLockToken() string {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("lock token: %v", err))
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// AcquireLock acquires the lock for ttlMs milliseconds if it is free or already
+// held by the token and returns the fencing token. The fencing token is
+// the record id of the lock at the moment of acquiring so it increases with
+// every new owner and stays the same while the lock is renewed.
+func (s *Server) AcquireLock(name, token string, ttlMs int64) (uint64, error) {
+
+	if err := s.validateKey(name); err != nil {
+		return 0, err
+	}
+
+	if ttlMs < 1 {
+		return 0, ErrExpiresInvalid
+	} else if ttlMs > MaxExpiresInMs {
+		return 0, ErrExpiresTooLarge
+	}
+
+	var fence uint64
+	err := ErrLockHeld
+
+	e := s.update(lockKeyPrefix+name, func(rec *sdk.Record, ok bool) int8 {
+
+		expires := sdk.NowMs() + ttlMs
+
+		if !ok {
+			*rec = *sdk.NewRecord(expires, []byte(token))
+		} else if string(rec.Value) == token {
+			rec.Expires = expires
+		} else {
+			return sdk.UpdateKeep
+		}
+
+		fence = rec.GetRecId()
+		err = nil
+		return sdk.UpdateStore
+	}, false)
+
+	if e != nil {
+		return 0, e
+	}
+
+	return fence, err
+}
+
+// RenewLock extends the lock held by the token for ttlMs milliseconds
+// and returns the fencing token
+func (s *Server) RenewLock(name, token string, ttlMs int64) (uint64, error) {
+
+	if err := s.validateKey(name); err != nil {
+		return 0, err
+	}
+
+	if ttlMs < 1 {
+		return 0, ErrExpiresInvalid
+	} else if ttlMs > MaxExpiresInMs {
+		return 0, ErrExpiresTooLarge
+	}
+
+	var fence uint64
+	var err error
+
+	e := s.update(lockKeyPrefix+name, func(rec *sdk.Record, ok bool) int8 {
+
+		if err = checkLockOwner(rec, ok, token); err != nil {
+			return sdk.UpdateKeep
+		}
+
+		rec.Expires = sdk.NowMs() + ttlMs
+		fence = rec.GetRecId()
+		return sdk.UpdateStore
+	}, false)
+
+	if e != nil {
+		return 0, e
+	}
+
+	return fence, err
+}
+
+// ReleaseLock frees the lock held by the token
+func (s *Server) ReleaseLock(name, token string) error {
+
+	if err := s.validateKey(name); err != nil {
+		return err
+	}
+
+	var err error
+
+	e := s.update(lockKeyPrefix+name, func(rec *sdk.Record, ok bool) int8 {
+
+		if err = checkLockOwner(rec, ok, token); err != nil {
+			return sdk.UpdateKeep
+		}
+
+		return sdk.UpdateDelete
+	}, false)
+
+	if e != nil {
+		return e
+	}
+
+	return err
+}
+
+// LockInfo returns the fencing token of the held lock and the number of
+// milliseconds left before it expires
+func (s *Server) LockInfo(name string) (uint64, int64, bool) {
+
+	now := sdk.NowMs()
+	rec, ok := (*s.cache).Lookup(sdk.KeyInfo{
+		Expires: now,
+		Key:     lockKeyPrefix + name,
+	})
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	return rec.GetRecId(), rec.Expires - now, true
+}
+
+func checkLockOwner(rec *sdk.Record, ok bool, token string) error {
+
+	if !ok {
+		return ErrLockNotFound
+	}
+
+	if string(rec.Value) != token {
+		return ErrLockNotOwned
+	}
+
+	return nil
+}
+
+func lockErrorCode(err error) int {
+
+	switch err {
+	case ErrLockHeld, ErrLockNotOwned:
+		return http.StatusConflict
+	case ErrLockNotFound:
+		return http.StatusNotFound
+	}
+
+	return http.StatusBadRequest
+}
+
+// writeFence responds with the fencing token in the body and
+// in header X-Fencing-Token
+func writeFence(t time.Time, w http.ResponseWriter, r *http.Request, fence uint64, ttlMs int64) {
+
+	value := strconv.FormatUint(fence, 10)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Fencing-Token", value)
+	w.Header().Set("X-Content-Expires-Sec", strconv.FormatInt((ttlMs+999)/1000, 10))
+	w.Header().Set("X-Content-Expires-Ms", strconv.FormatInt(ttlMs, 10))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(value))
+	log.Printf(requestInfo(t, http.StatusOK, r, "fence:%d expires_ms:%d", fence, ttlMs))
+}
+
+func writeLockError(t time.Time, w http.ResponseWriter, r *http.Request, err error) {
+
+	code := lockErrorCode(err)
+
+	w.WriteHeader(code)
+	w.Write([]byte(err.Error()))
+	log.Printf(requestInfo(t, code, r, "error:%s", err.Error()))
+}
+
+// Acquire the lock by POST /_lock/somelock. The owner token is taken from
+// header X-Lock-Token or generated if the header is absent.
+func (s *Server) acquireLockHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	ttl, err := s.parseHeaderContentExpires(r)
+	if err != nil {
+		writeLockError(t, w, r, err)
+		return
+	}
+
+	token := r.Header.Get("X-Lock-Token")
+	if token == "" {
+		token = NewLockToken()
+	}
+
+	fence, err := s.AcquireLock(pathToLock(r.URL.Path), token, ttl)
+	if err != nil {
+		writeLockError(t, w, r, err)
+		return
+	}
+
+	w.Header().Set("X-Lock-Token", token)
+	writeFence(t, w, r, fence, ttl)
+}
+
+// Renew the lock held by the owner token in header X-Lock-Token
+// by PUT /_lock/somelock
+func (s *Server) renewLockHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	ttl, err := s.parseHeaderContentExpires(r)
+	if err != nil {
+		writeLockError(t, w, r, err)
+		return
+	}
+
+	fence, err := s.RenewLock(pathToLock(r.URL.Path), r.Header.Get("X-Lock-Token"), ttl)
+	if err != nil {
+		writeLockError(t, w, r, err)
+		return
+	}
+
+	writeFence(t, w, r, fence, ttl)
+}
+
+// Release the lock held by the owner token in header X-Lock-Token
+// by DELETE /_lock/somelock
+func (s *Server) releaseLockHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	if err := s.ReleaseLock(pathToLock(r.URL.Path), r.Header.Get("X-Lock-Token")); err != nil {
+		writeLockError(t, w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Printf(requestInfo(t, http.StatusOK, r, ""))
+}
+
+// Get the fencing token of the held lock by GET /_lock/somelock
+func (s *Server) lockInfoHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	fence, ttl, ok := s.LockInfo(pathToLock(r.URL.Path))
+	if !ok {
+		writeLockError(t, w, r, ErrLockNotFound)
+		return
+	}
+
+	writeFence(t, w, r, fence, ttl)
+}
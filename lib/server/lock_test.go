@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+
+	s, _ := newTestServer()
+
+	fence, err := s.AcquireLock("job", "a", 10000)
+	if err != nil {
+		t.Fatalf("AcquireLock() error %s", err.Error())
+	}
+
+	if _, err = s.AcquireLock("job", "b", 10000); err != ErrLockHeld {
+		t.Errorf("AcquireLock() by another owner = %v; wants %v", err, ErrLockHeld)
+	}
+
+	if renewed, err := s.RenewLock("job", "a", 20000); err != nil || renewed != fence {
+		t.Errorf("RenewLock() = %d, %v; wants %d, nil", renewed, err, fence)
+	}
+
+	if _, err = s.RenewLock("job", "b", 20000); err != ErrLockNotOwned {
+		t.Errorf("RenewLock() by another owner = %v; wants %v", err, ErrLockNotOwned)
+	}
+
+	if err = s.ReleaseLock("job", "b"); err != ErrLockNotOwned {
+		t.Errorf("ReleaseLock() by another owner = %v; wants %v", err, ErrLockNotOwned)
+	}
+
+	if err = s.ReleaseLock("job", "a"); err != nil {
+		t.Errorf("ReleaseLock() error %s", err.Error())
+	}
+
+	if err = s.ReleaseLock("job", "a"); err != ErrLockNotFound {
+		t.Errorf("ReleaseLock() of free lock = %v; wants %v", err, ErrLockNotFound)
+	}
+
+	next, err := s.AcquireLock("job", "b", 10000)
+	if err != nil || next <= fence {
+		t.Errorf("AcquireLock() = %d, %v; wants fencing token greater than %d", next, err, fence)
+	}
+
+	if s.Exists(lockKeyPrefix+"job") || s.Delete(lockKeyPrefix+"job") {
+		t.Errorf("lock is available through operations on keys")
+	}
+}
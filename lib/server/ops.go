@@ -0,0 +1,346 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// Operations on keys shared by every API of the server. They keep the cache,
+// the scheduler and the replication log consistent with each other so
+// protocol handlers should use them instead of calling the components.
+
+// MaxExpiresInMs is the longest expiration time, 100 years. It keeps
+// the expiration time in unix milliseconds far from overflowing int64.
+const MaxExpiresInMs = 100 * 365 * 24 * 3600 * 1000
+
+// Modes of Set
+const (
+	SetAlways   int8 = iota // insert a new key or overwrite existed one
+	SetIfAbsent             // insert only if the key doesn't exist
+	SetIfExists             // overwrite only if the key exists
+)
+
+var (
+	ErrExpiresInvalid  = errors.New("Expiration time should be greater than 0")
+	ErrExpiresTooLarge = errors.New("Expiration time should not be greater than 100 years")
+	ErrKeyReserved     = fmt.Errorf("Keys starting with '%s' are reserved", sdk.ReservedKeyPrefix)
+	ErrNotInteger      = errors.New("Value is not an integer or out of range")
+	ErrValueTooLarge   = errors.New("Value is too large")
+	ErrWrongType       = errors.New("Operation against a key holding the wrong kind of value")
+)
+
+// replicate writes the change to the replication log and notifies subscribers
+func (s *Server) replicate(action int8, key sdk.KeyInfo, rec *sdk.Record) {
+
+	item := sdk.NewReplItem(action, key, *rec) // TODO remove unnessasery copy of []bytes here
+
+	s.replicateItem(item)
+}
+
+func (s *Server) replicateItem(item *sdk.ReplItem) {
+
+	(*s.repl).Add(*item)
+	s.events.publish(*item)
+}
+
+// Get looks up the record which is not expired at the moment
+func (s *Server) Get(key string) (sdk.Record, bool) {
+
+	if sdk.IsReserved(key) {
+		return sdk.Record{}, false
+	}
+
+	return (*s.cache).Lookup(sdk.KeyInfo{
+		Expires: sdk.NowMs(),
+		Key:     key,
+	})
+}
+
+// Exists reports whether the key exists and is not expired at the moment
+func (s *Server) Exists(key string) bool {
+
+	_, ok := s.Get(key)
+	return ok
+}
+
+// TTL returns the number of milliseconds left before the key expires
+func (s *Server) TTL(key string) (int64, bool) {
+
+	if sdk.IsReserved(key) {
+		return 0, false
+	}
+
+	now := sdk.NowMs()
+	rec, ok := (*s.cache).Lookup(sdk.KeyInfo{
+		Expires: now,
+		Key:     key,
+	})
+
+	if !ok {
+		return 0, false
+	}
+
+	return rec.Expires - now, true
+}
+
+// Update atomically modifies the record of the key by fn and keeps
+// the scheduler and the replication log in sync with the result.
+// A stored record gets a new record id and the default expiration time
+// if fn hasn't set any. Too large values are not stored.
+func (s *Server) Update(key string, fn sdk.UpdateFunc) error {
+
+	if err := s.validateKey(key); err != nil {
+		return err
+	}
+
+	return s.update(key, fn, true)
+}
+
+// update is Update of a valid key. A stored record keeps its record id
+// unless renew is set.
+func (s *Server) update(key string, fn sdk.UpdateFunc, renew bool) error {
+
+	now := sdk.NowMs()
+	var action int8
+	var rec sdk.Record
+	var err error
+	var expires int64
+	existed := false
+	rescheduled := false
+
+	(*s.cache).Update(sdk.KeyInfo{Expires: now, Key: key},
+		func(cur *sdk.Record, ok bool) int8 {
+
+			expires = cur.HardExpires()
+			existed = ok
+			action = fn(cur, ok)
+
+			if action != sdk.UpdateStore {
+				return action
+			}
+
+			if int64(len(cur.Value)) > s.cfg.MaxValueBytes {
+				err = ErrValueTooLarge
+				action = sdk.UpdateKeep
+				return action
+			}
+
+			if cur.Expires == 0 {
+				cur.Expires = now + s.cfg.ExpiresDefaultDurationSec*1000
+			}
+
+			if renew {
+				cur.Renew()
+			}
+			rec = *cur
+			rescheduled = !ok || cur.HardExpires() != expires
+			return action
+		})
+
+	switch action {
+	case sdk.UpdateStore:
+		keyinfo := sdk.KeyInfo{
+			Expires: rec.Expires,
+			Key:     key,
+		}
+
+		s.replicate(sdk.ReplActionInsert, keyinfo, &rec)
+		if rescheduled {
+			// stale records are deleted at the hard expiration time
+			(*s.sched).Add(sdk.KeyInfo{Expires: rec.HardExpires(), Key: key})
+		}
+
+	case sdk.UpdateDelete:
+		if existed {
+			s.replicateDelete(key)
+			(*s.sched).Cancel(sdk.KeyInfo{Expires: expires, Key: key})
+		}
+	}
+
+	return err
+}
+
+// Set stores the value according to mode and reports whether it was stored.
+// The default expiration time is used if expiresInMs is 0.
+func (s *Server) Set(key string, value []byte, expiresInMs int64, mode int8) (bool, error) {
+
+	if expiresInMs == 0 {
+		expiresInMs = s.cfg.ExpiresDefaultDurationSec * 1000
+	} else if expiresInMs < 0 {
+		return false, ErrExpiresInvalid
+	} else if expiresInMs > MaxExpiresInMs {
+		return false, ErrExpiresTooLarge
+	}
+
+	if mode != SetAlways {
+		stored := false
+		err := s.Update(key, func(rec *sdk.Record, ok bool) int8 {
+
+			if ok != (mode == SetIfExists) {
+				return sdk.UpdateKeep
+			}
+
+			*rec = sdk.Record{
+				Expires: sdk.NowMs() + expiresInMs,
+				Value:   value,
+			}
+			stored = true
+			return sdk.UpdateStore
+		})
+
+		return stored && err == nil, err
+	}
+
+	if err := s.validateKey(key); err != nil {
+		return false, err
+	}
+
+	if int64(len(value)) > s.cfg.MaxValueBytes {
+		return false, ErrValueTooLarge
+	}
+
+	keyinfo := sdk.KeyInfo{
+		Expires: sdk.NowMs() + expiresInMs,
+		Key:     key,
+	}
+
+	rec := sdk.NewRecord(keyinfo.Expires, value) // TODO remove unnessasery copy of []bytes here
+	(*s.cache).Insert(keyinfo, *rec)             // TODO remove unnessasery copy of []bytes here
+
+	s.replicate(sdk.ReplActionInsert, keyinfo, rec)
+	(*s.sched).Add(keyinfo)
+
+	return true, nil
+}
+
+// Delete removes the key and reports whether it existed
+func (s *Server) Delete(key string) bool {
+
+	if sdk.IsReserved(key) {
+		return false
+	}
+
+	found := false
+	var expires int64
+
+	(*s.cache).Update(sdk.KeyInfo{Expires: sdk.NowMs(), Key: key},
+		func(cur *sdk.Record, ok bool) int8 {
+			found = ok
+			expires = cur.HardExpires()
+			// remove record regardless of it's expires date
+			return sdk.UpdateDelete
+		})
+
+	// Replicate only found keys to avoid overpopulation of replication
+	// log's bucket. Expired keys are deleted on replicas by the scheduler.
+	if found {
+		s.replicateDelete(key)
+		(*s.sched).Cancel(sdk.KeyInfo{Expires: expires, Key: key})
+	}
+
+	return found
+}
+
+func (s *Server) replicateDelete(key string) {
+
+	keyinfo := sdk.KeyInfo{
+		Expires: math.MaxInt64,
+		Key:     key,
+	}
+
+	s.replicate(sdk.ReplActionDelete, keyinfo, sdk.NewRecord(0, nil))
+}
+
+// Expire sets a new expiration time of the key and reports whether the key
+// exists. The key is deleted if expiresInMs is not greater than 0.
+// The expiration time is limited by MaxExpiresInMs.
+func (s *Server) Expire(key string, expiresInMs int64) bool {
+
+	if expiresInMs < 1 {
+		return s.Delete(key)
+	}
+
+	if expiresInMs > MaxExpiresInMs {
+		expiresInMs = MaxExpiresInMs
+	}
+
+	found := false
+	s.Update(key, func(rec *sdk.Record, ok bool) int8 {
+
+		if found = ok; !ok {
+			return sdk.UpdateKeep
+		}
+
+		rec.Expires = sdk.NowMs() + expiresInMs
+		return sdk.UpdateStore
+	})
+
+	return found
+}
+
+// Incr adds delta to the integer value of the key and returns the result.
+// Absent key is created with value 0 and the default expiration time.
+func (s *Server) Incr(key string, delta int64) (int64, error) {
+
+	var result int64
+	var err error
+
+	e := s.Update(key, func(rec *sdk.Record, ok bool) int8 {
+
+		if ok && !rec.IsPlain() {
+			err = ErrWrongType
+			return sdk.UpdateKeep
+		}
+
+		if result, err = incrValue(rec.Value, ok, delta); err != nil {
+			return sdk.UpdateKeep
+		}
+
+		rec.Value = []byte(strconv.FormatInt(result, 10))
+		return sdk.UpdateStore
+	})
+
+	if e != nil {
+		return 0, e
+	}
+
+	return result, err
+}
+
+// incrValue adds delta to the integer value, absent value is 0
+func incrValue(value []byte, ok bool, delta int64) (int64, error) {
+
+	var x int64
+	var err error
+
+	if ok {
+		if x, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+
+	if (delta > 0 && x > math.MaxInt64-delta) || (delta < 0 && x < math.MinInt64-delta) {
+		return 0, ErrNotInteger
+	}
+
+	return x + delta, nil
+}
+
+// Flush removes all keys of namespace or all keys at all if namespace
+// is empty.
+func (s *Server) Flush(namespace string) {
+
+	// flush marker gets a new record id to keep the order of replication log
+	keyinfo := sdk.KeyInfo{
+		Expires: 0,
+		Key:     namespace,
+	}
+
+	(*s.cache).Flush(namespace)
+	(*s.sched).Flush(namespace)
+	s.replicate(sdk.ReplActionFlush, keyinfo, sdk.NewRecord(0, nil))
+}
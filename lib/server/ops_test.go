@@ -0,0 +1,101 @@
+package server
+
+import (
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSetExpiresMs(t *testing.T) {
+
+	s, _ := newTestServer()
+
+	if _, err := s.Set("ms", []byte("x"), 50, SetAlways); err != nil {
+		t.Fatalf("Set() error %s", err.Error())
+	}
+
+	if ttl, ok := s.TTL("ms"); !ok || ttl < 1 || ttl > 50 {
+		t.Errorf("TTL() = %d, %v; wants up to 50ms", ttl, ok)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if s.Exists("ms") {
+		t.Errorf("key is not expired in 60ms")
+	}
+}
+
+func TestParseHeaderContentExpires(t *testing.T) {
+
+	s, _ := newTestServer()
+
+	table := []struct {
+		sec     string
+		ms      string
+		expires int64
+	}{
+		{"", "", s.cfg.ExpiresDefaultDurationSec * 1000},
+		{"5", "", 5000},
+		{"", "300", 300},
+		{"5", "300", 300},
+	}
+
+	for _, x := range table {
+		r := httptest.NewRequest("PUT", "/key", nil)
+		if x.sec != "" {
+			r.Header.Set("X-Content-Expires-Sec", x.sec)
+		}
+		if x.ms != "" {
+			r.Header.Set("X-Content-Expires-Ms", x.ms)
+		}
+
+		expires, err := s.parseHeaderContentExpires(r)
+		if err != nil || expires != x.expires {
+			t.Errorf("parseHeaderContentExpires(sec %q, ms %q) = %d, %v; wants %d",
+				x.sec, x.ms, expires, err, x.expires)
+		}
+	}
+
+	// too large values would overflow unix time in milliseconds
+	invalid := []struct {
+		header string
+		value  string
+	}{
+		{"X-Content-Expires-Ms", "0.5"},
+		{"X-Content-Expires-Ms", strconv.FormatInt(MaxExpiresInMs+1, 10)},
+		{"X-Content-Expires-Sec", strconv.FormatInt(MaxExpiresInMs/1000+1, 10)},
+		{"X-Content-Expires-Sec", "9223372036854775"},
+	}
+
+	for _, x := range invalid {
+		r := httptest.NewRequest("PUT", "/key", nil)
+		r.Header.Set(x.header, x.value)
+		if _, err := s.parseHeaderContentExpires(r); err == nil {
+			t.Errorf("parseHeaderContentExpires(%s %q) error is nil", x.header, x.value)
+		}
+	}
+}
+
+func TestExpiresTooLarge(t *testing.T) {
+
+	s, _ := newTestServer()
+
+	if _, err := s.Set("large", []byte("x"), MaxExpiresInMs+1, SetAlways); err != ErrExpiresTooLarge {
+		t.Errorf("Set() of too large expiration time error %v; wants %v", err, ErrExpiresTooLarge)
+	}
+
+	if _, err := s.Push("large", ListTail, [][]byte{[]byte("x")}, MaxExpiresInMs+1); err != ErrExpiresTooLarge {
+		t.Errorf("Push() of too large expiration time error %v; wants %v", err, ErrExpiresTooLarge)
+	}
+
+	s.Set("large", []byte("x"), 0, SetAlways)
+	if !s.Expire("large", math.MaxInt64) {
+		t.Fatalf("Expire() of existing key = false")
+	}
+
+	if ttl, ok := s.TTL("large"); !ok || ttl < MaxExpiresInMs-1000 || ttl > MaxExpiresInMs {
+		t.Errorf("TTL() after too large Expire() = %d, %v; wants %d", ttl, ok, int64(MaxExpiresInMs))
+	}
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// Failed loads from origins are stored as keys of the reserved namespace
+// for origin_negative_ttl_sec. The value is the error message and the flags
+// are the status code of the response.
+const originKeyPrefix = "_origin/"
+
+// OriginError is the failure of the load from the origin
+type OriginError struct {
+	Code    int // the status code to respond with
+	Message string
+}
+
+func (e *OriginError) Error() string {
+	return e.Message
+}
+
+// flightGroup collapses concurrent loads of the same key into one
+type flightGroup struct {
+	m     sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	wg  sync.WaitGroup
+	rec sdk.Record
+	err error
+}
+
+// do calls fn once for all callers waiting for the key at the same time
+func (g *flightGroup) do(key string, fn func() (sdk.Record, error)) (sdk.Record, error) {
+
+	g.m.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.m.Unlock()
+		c.wg.Wait()
+		return c.rec, c.err
+	}
+
+	c := &flight{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.m.Unlock()
+
+	c.rec, c.err = fn()
+
+	g.m.Lock()
+	delete(g.calls, key)
+	g.m.Unlock()
+	c.wg.Done()
+
+	return c.rec, c.err
+}
+
+// originOf returns the origin with the longest prefix of the key
+func (s *Server) originOf(key string) (config.Origin, bool) {
+
+	var result config.Origin
+	found := false
+
+	for _, x := range s.cfg.Origins {
+		if strings.HasPrefix(key, x.Prefix) && (!found || len(x.Prefix) > len(result.Prefix)) {
+			result = x
+			found = true
+		}
+	}
+
+	return result, found
+}
+
+// Load returns the value of the key fetching it from the origin if the key
+// is absent. Concurrent loads of the same key make one request to the origin.
+// The fetched value is stored for the time allowed by the Cache-Control
+// header of the response. It reports false if no origin serves the key.
+//
+// The expired value is returned stale while it's fetched again in background
+// during stale_while_revalidate_sec of the origin and instead of the error
+// of the origin during stale_if_error_sec. Use IsStale to tell it apart.
+func (s *Server) Load(key string) (sdk.Record, bool, error) {
+
+	if rec, ok := s.Get(key); ok {
+		return rec, true, nil
+	}
+
+	origin, ok := s.originOf(key)
+	if !ok {
+		return sdk.Record{}, false, nil
+	}
+
+	if hasDotSegments(strings.TrimPrefix(key, origin.Prefix)) {
+		return sdk.Record{}, true, &OriginError{
+			Code:    http.StatusBadRequest,
+			Message: "Keys served by origins should not have '.' or '..' path segments",
+		}
+	}
+
+	now := sdk.NowMs()
+	stale, hasStale := s.getStale(key, now)
+
+	if hasStale && now < stale.Expires+origin.StaleWhileRevalidateSec*1000 {
+		go s.flights.do(key, func() (sdk.Record, error) {
+			return s.load(origin, key)
+		})
+
+		return stale, true, nil
+	}
+
+	rec, err := s.flights.do(key, func() (sdk.Record, error) {
+		return s.load(origin, key)
+	})
+
+	if err != nil && hasStale && now < stale.Expires+origin.StaleIfErrorSec*1000 {
+		return stale, true, nil
+	}
+
+	return rec, true, err
+}
+
+// hasDotSegments reports whether the path has segments which could resolve
+// outside of the path of the origin
+func hasDotSegments(path string) bool {
+
+	for _, x := range strings.Split(path, "/") {
+		if x == "." || x == ".." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsStale reports whether the record returned by Load is expired
+func IsStale(rec sdk.Record, now int64) bool {
+	return rec.StaleExpires != 0 && rec.Expires <= now
+}
+
+// getStale returns the expired record kept to be served stale
+func (s *Server) getStale(key string, now int64) (sdk.Record, bool) {
+
+	// the record of any expiration time is found at the moment 0
+	rec, ok := (*s.cache).Lookup(sdk.KeyInfo{Expires: 0, Key: key})
+	if !ok || !IsStale(rec, now) || rec.StaleExpires <= now {
+		return sdk.Record{}, false
+	}
+
+	return rec, true
+}
+
+// load fetches the key from the origin unless the key is already loaded
+// or the origin failed recently
+func (s *Server) load(origin config.Origin, key string) (sdk.Record, error) {
+
+	// the key could be loaded by the flight finished just before this one
+	if rec, ok := s.Get(key); ok {
+		return rec, nil
+	}
+
+	if rec, ok := (*s.cache).Lookup(sdk.KeyInfo{
+		Expires: sdk.NowMs(),
+		Key:     originKeyPrefix + key,
+	}); ok {
+		return sdk.Record{}, &OriginError{Code: int(rec.Flags), Message: string(rec.Value)}
+	}
+
+	rec, err := s.fetch(origin, key)
+	if err != nil {
+		s.opsOriginErrorsTotal.Inc()
+		s.storeOriginError(key, err.(*OriginError))
+	}
+
+	return rec, err
+}
+
+// fetch requests the key from the origin and stores the value
+func (s *Server) fetch(origin config.Origin, key string) (sdk.Record, error) {
+
+	s.opsOriginRequestsTotal.Inc()
+
+	u := origin.URL + (&url.URL{Path: strings.TrimPrefix(key, origin.Prefix)}).EscapedPath()
+
+	resp, err := s.originClient.Get(u)
+	if err != nil {
+		return sdk.Record{}, &OriginError{
+			Code:    http.StatusBadGateway,
+			Message: fmt.Sprintf("Origin request failed: %s", err.Error()),
+		}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return sdk.Record{}, &OriginError{Code: http.StatusNotFound, Message: "Key is not found in origin"}
+	case resp.StatusCode != http.StatusOK:
+		return sdk.Record{}, &OriginError{
+			Code:    http.StatusBadGateway,
+			Message: fmt.Sprintf("Origin responded with status %d", resp.StatusCode),
+		}
+	}
+
+	value, err := ioutil.ReadAll(io.LimitReader(resp.Body, s.cfg.MaxValueBytes+1))
+	if err != nil {
+		return sdk.Record{}, &OriginError{
+			Code:    http.StatusBadGateway,
+			Message: fmt.Sprintf("Origin response failed: %s", err.Error()),
+		}
+	}
+
+	if int64(len(value)) > s.cfg.MaxValueBytes {
+		return sdk.Record{}, &OriginError{Code: http.StatusBadGateway, Message: ErrValueTooLarge.Error()}
+	}
+
+	ttl, store := responseTTL(resp.Header, s.cfg.ExpiresDefaultDurationSec)
+	if !store {
+		return *sdk.NewRecord(sdk.NowMs(), value), nil
+	}
+
+	var rec sdk.Record
+	expires := sdk.NowMs() + ttl*1000
+
+	err = s.update(key, func(cur *sdk.Record, ok bool) int8 {
+
+		*cur = sdk.Record{Expires: expires, Value: value}
+
+		// the record is kept after expiration to be served stale
+		if stale := origin.StaleWhileRevalidateSec; stale > 0 || origin.StaleIfErrorSec > 0 {
+			if origin.StaleIfErrorSec > stale {
+				stale = origin.StaleIfErrorSec
+			}
+			cur.StaleExpires = expires + stale*1000
+		}
+
+		rec = *cur
+		return sdk.UpdateStore
+	}, true)
+
+	if err != nil {
+		return sdk.Record{}, &OriginError{Code: http.StatusBadGateway, Message: err.Error()}
+	}
+
+	return rec, nil
+}
+
+func (s *Server) storeOriginError(key string, oerr *OriginError) {
+
+	s.update(originKeyPrefix+key, func(rec *sdk.Record, ok bool) int8 {
+
+		*rec = sdk.Record{
+			Expires: sdk.NowMs() + s.cfg.OriginNegativeTtlSec*1000,
+			Flags:   uint32(oerr.Code),
+			Value:   []byte(oerr.Message),
+		}
+
+		return sdk.UpdateStore
+	}, true)
+}
+
+// responseTTL returns the number of seconds the response may be cached
+// for according to its Cache-Control or Expires headers and reports
+// whether it may be cached at all. def is used if the headers are absent.
+func responseTTL(h http.Header, def int64) (int64, bool) {
+
+	var maxAge, sMaxAge int64 = -1, -1
+
+	for _, x := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, val := x, ""
+		if i := strings.IndexByte(x, '='); i >= 0 {
+			name, val = x[:i], strings.Trim(x[i+1:], `" `)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "no-store", "no-cache", "private":
+			return 0, false
+		case "max-age":
+			maxAge, _ = strconv.ParseInt(val, 10, 64)
+		case "s-maxage":
+			sMaxAge, _ = strconv.ParseInt(val, 10, 64)
+		}
+	}
+
+	ttl := def
+	switch {
+	case sMaxAge >= 0:
+		ttl = sMaxAge
+	case maxAge >= 0:
+		ttl = maxAge
+	case h.Get("Expires") != "":
+		expires, err := http.ParseTime(h.Get("Expires"))
+		if err != nil {
+			return 0, false
+		}
+		ttl = int64(time.Until(expires) / time.Second)
+	}
+
+	return ttl, ttl > 0
+}
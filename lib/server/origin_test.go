@@ -0,0 +1,156 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+func TestOriginLoad(t *testing.T) {
+
+	var requests int64
+	release := make(chan struct{})
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+
+		switch r.URL.Path {
+		case "/slow":
+			<-release
+			w.Header().Set("Cache-Control", "public, max-age=120")
+			w.Write([]byte("value"))
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+			w.Write([]byte("value"))
+		default:
+			http.Error(w, "failure", http.StatusInternalServerError)
+		}
+	}))
+	defer origin.Close()
+
+	s, _ := newTestServer()
+	s.cfg.Origins = []config.Origin{{Prefix: "origin/", URL: origin.URL + "/"}}
+	defer func() { s.cfg.Origins = nil }()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rec, ok, err := s.Load("origin/slow"); err != nil || !ok || string(rec.Value) != "value" {
+				t.Errorf("Load() = %q, %v, %v; wants value", rec.Value, ok, err)
+			}
+		}()
+	}
+
+	// let the loads join the flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt64(&requests); n != 1 {
+		t.Errorf("concurrent loads made %d requests; wants 1", n)
+	}
+
+	if ttl, ok := s.TTL("origin/slow"); !ok || ttl < 119000 || ttl > 120000 {
+		t.Errorf("TTL() of loaded key = %d, %v; wants 120 from Cache-Control", ttl, ok)
+	}
+
+	s.Load("origin/nostore")
+	if s.Exists("origin/nostore") {
+		t.Errorf("response with no-store is stored")
+	}
+
+	for i := 0; i < 2; i++ {
+		_, _, err := s.Load("origin/fail")
+		if oerr, ok := err.(*OriginError); !ok || oerr.Code != http.StatusBadGateway {
+			t.Errorf("Load() of failing key = %v; wants origin error with code 502", err)
+		}
+	}
+
+	if n := atomic.LoadInt64(&requests); n != 3 {
+		t.Errorf("made %d requests; wants 3 with the failure cached", n)
+	}
+
+	if _, ok, _ := s.Load("other"); ok {
+		t.Errorf("Load() of key without origin reports ok")
+	}
+
+	// keys can't escape the path of the origin
+	for _, key := range []string{"origin/../admin", "origin/a/./b", "origin/.."} {
+		_, _, err := s.Load(key)
+		if oerr, ok := err.(*OriginError); !ok || oerr.Code != http.StatusBadRequest {
+			t.Errorf("Load(%s) = %v; wants origin error with code 400", key, err)
+		}
+	}
+
+	if n := atomic.LoadInt64(&requests); n != 3 {
+		t.Errorf("made %d requests; wants no requests of keys with dot segments", n)
+	}
+}
+
+func TestOriginStale(t *testing.T) {
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "fail") {
+			http.Error(w, "failure", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("fresh"))
+	}))
+	defer origin.Close()
+
+	s, _ := newTestServer()
+	s.cfg.Origins = []config.Origin{
+		{Prefix: "swr/", URL: origin.URL + "/", StaleWhileRevalidateSec: 10},
+		{Prefix: "sie/", URL: origin.URL + "/", StaleIfErrorSec: 100},
+	}
+	defer func() { s.cfg.Origins = nil }()
+
+	now := sdk.NowMs()
+	for _, key := range []string{"swr/ok", "sie/fail", "sie/old/fail"} {
+		staleExpires := now + 50000
+		if key == "sie/old/fail" {
+			staleExpires = now
+		}
+
+		(*s.cache).Insert(sdk.KeyInfo{Expires: now - 1000, Key: key},
+			sdk.Record{Expires: now - 1000, StaleExpires: staleExpires, Value: []byte("stale")})
+	}
+
+	rec, ok, err := s.Load("swr/ok")
+	if err != nil || !ok || string(rec.Value) != "stale" || !IsStale(rec, now) {
+		t.Errorf("Load() in stale-while-revalidate = %q, %v, %v; wants stale value", rec.Value, ok, err)
+	}
+
+	// the value is fetched again in background
+	for i := 0; i < 100; i++ {
+		if rec, ok = s.Get("swr/ok"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if string(rec.Value) != "fresh" || rec.StaleExpires != rec.Expires+10000 {
+		t.Errorf("revalidated record = %q stale expires %d; wants fresh kept 10s after %d",
+			rec.Value, rec.StaleExpires, rec.Expires)
+	}
+
+	rec, ok, err = s.Load("sie/fail")
+	if err != nil || !ok || string(rec.Value) != "stale" {
+		t.Errorf("Load() in stale-if-error = %q, %v, %v; wants stale value", rec.Value, ok, err)
+	}
+
+	if _, _, err = s.Load("sie/old/fail"); err == nil {
+		t.Errorf("Load() after stale time returned no error")
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// Messages published to a channel are delivered to the current subscribers
+// of the channel only, they are never stored in the cache.
+const pubsubPath = "/_pubsub/"
+
+type pubsubEvent struct {
+	Channel string `json:"channel"`
+	Message []byte `json:"message"`
+}
+
+func pathToChannel(path string) string {
+	return strings.TrimPrefix(path, pubsubPath)
+}
+
+// Publish delivers the message to subscribers of the channel and returns
+// the number of subscribers received it. With pubsub_replicate the message
+// is written to the replication log too.
+// Channel names follow the same rules as keys.
+func (s *Server) Publish(channel string, message []byte) (int, error) {
+
+	if err := s.validateKey(channel); err != nil {
+		return 0, err
+	}
+
+	if int64(len(message)) > s.cfg.MaxValueBytes {
+		return 0, ErrValueTooLarge
+	}
+
+	item := sdk.NewReplItem(sdk.ReplActionPublish, sdk.KeyInfo{Key: channel}, *sdk.NewRecord(0, message))
+
+	if s.cfg.PubsubReplicate {
+		(*s.repl).Add(*item)
+	}
+
+	s.opsPublishedTotal.Inc()
+
+	return s.channels.publish(*item), nil
+}
+
+// DeliverReplicated delivers the publish item received from the replication
+// log to local subscribers of the channel. Other items are ignored.
+func (s *Server) DeliverReplicated(item sdk.ReplItem) int {
+
+	if item.Action != sdk.ReplActionPublish {
+		return 0
+	}
+
+	return s.channels.publish(item)
+}
+
+// SubscribeChannel returns a subscription to messages of the channel
+func (s *Server) SubscribeChannel(channel string) *Subscription {
+	return s.channels.subscribe(channel, true)
+}
+
+// Publish the body of POST /_pubsub/somechannel. The response body
+// is the number of subscribers received the message.
+func (s *Server) publishHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	message, ok := s.readValue(t, w, r)
+	if !ok {
+		return
+	}
+
+	n, err := s.Publish(pathToChannel(r.URL.Path), message)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		log.Printf(requestInfo(t, http.StatusBadRequest, r, "error:%s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(strconv.Itoa(n)))
+	log.Printf(requestInfo(t, http.StatusOK, r, "receivers:%d", n))
+}
+
+// Stream messages of GET /_pubsub/somechannel as Server-Sent Events
+func (s *Server) channelHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	sub := s.SubscribeChannel(pathToChannel(r.URL.Path))
+
+	streamEvents(t, w, r, sub, func(item sdk.ReplItem) (uint64, string, interface{}) {
+
+		event := pubsubEvent{
+			Channel: item.Key.Key,
+			Message: item.Value.Value,
+		}
+
+		return item.Value.GetRecId(), "message", event
+	})
+}
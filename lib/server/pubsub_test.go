@@ -0,0 +1,56 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+func TestPublish(t *testing.T) {
+
+	s, repl := newTestServer()
+	replicated := len(repl.items)
+
+	sub := s.SubscribeChannel("news")
+	defer sub.Close()
+	other := s.SubscribeChannel("news/sport")
+	defer other.Close()
+
+	n, err := s.Publish("news", []byte("hello"))
+	if err != nil || n != 1 {
+		t.Fatalf("Publish() = %d, %v; wants 1, nil", n, err)
+	}
+
+	if _, err = s.Publish("_news", []byte("hello")); err == nil {
+		t.Errorf("Publish() wants error for reserved channel")
+	}
+
+	item := <-sub.C
+	if item.Key.Key != "news" || string(item.Value.Value) != "hello" {
+		t.Errorf("received %s %q; wants news \"hello\"", item.Key.Key, item.Value.Value)
+	}
+
+	if len(other.C) != 0 {
+		t.Errorf("subscriber of another channel received the message")
+	}
+
+	// messages are written to the replication log only with pubsub_replicate
+	if items := repl.items[replicated:]; len(items) != 0 {
+		t.Errorf("replicated %v without pubsub_replicate; wants nothing", items)
+	}
+
+	s.cfg.PubsubReplicate = true
+	defer func() { s.cfg.PubsubReplicate = false }()
+
+	s.Publish("news", []byte("hello"))
+	<-sub.C
+
+	items := repl.items[replicated:]
+	if len(items) != 1 || items[0].Action != sdk.ReplActionPublish {
+		t.Fatalf("replicated %v; wants one publish item", items)
+	}
+
+	if n = s.DeliverReplicated(items[0]); n != 1 {
+		t.Errorf("DeliverReplicated() = %d; wants 1", n)
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// Rate limiters are stored as keys of the reserved namespace like locks.
+// The value of a limiter is the state of its sliding window. The limiter
+// expires after two windows without hits. The path is reserved as well,
+// /ratelimit/ would be the path of plain keys of the ratelimit namespace.
+const rateLimitPath = "/_ratelimit/"
+const rateLimitKeyPrefix = "_ratelimit/"
+
+var ErrRateLimitInvalid = errors.New("Parameters limit and window must be positive")
+
+// RateLimitResult is the outcome of a hit of the rate limiter
+type RateLimitResult struct {
+	Allowed   bool  `json:"allowed"`
+	Limit     int64 `json:"limit"`
+	Remaining int64 `json:"remaining"`
+	// Reset is the unix time when the limiter has the whole limit again
+	Reset int64 `json:"reset"`
+	// RetryAfter is the number of seconds before the next hit can be allowed.
+	// It's set only if the hit is denied.
+	RetryAfter int64 `json:"retry_after,omitempty"`
+}
+
+// rateWindow approximates the sliding window by two fixed windows:
+// the hits of the previous window are weighted by the part of it
+// still covered by the sliding window. All times are in milliseconds.
+type rateWindow struct {
+	window int64
+	start  int64 // the start of the current fixed window
+	prev   int64 // the number of hits in the previous fixed window
+	curr   int64 // the number of hits in the current fixed window
+}
+
+const rateWindowSize = 32
+
+func (rw *rateWindow) encode() []byte {
+
+	b := make([]byte, rateWindowSize)
+	binary.BigEndian.PutUint64(b[0:], uint64(rw.window))
+	binary.BigEndian.PutUint64(b[8:], uint64(rw.start))
+	binary.BigEndian.PutUint64(b[16:], uint64(rw.prev))
+	binary.BigEndian.PutUint64(b[24:], uint64(rw.curr))
+
+	return b
+}
+
+func decodeRateWindow(b []byte) (rateWindow, bool) {
+
+	if len(b) != rateWindowSize {
+		return rateWindow{}, false
+	}
+
+	return rateWindow{
+		window: int64(binary.BigEndian.Uint64(b[0:])),
+		start:  int64(binary.BigEndian.Uint64(b[8:])),
+		prev:   int64(binary.BigEndian.Uint64(b[16:])),
+		curr:   int64(binary.BigEndian.Uint64(b[24:])),
+	}, true
+}
+
+// advance moves the current fixed window to the one containing now
+func (rw *rateWindow) advance(now int64) {
+
+	if now < rw.start+rw.window {
+		return
+	}
+
+	n := (now - rw.start) / rw.window
+	if n == 1 {
+		rw.prev = rw.curr
+	} else {
+		rw.prev = 0
+	}
+
+	rw.curr = 0
+	rw.start += n * rw.window
+}
+
+// count returns the estimated number of hits in the sliding window ending at now
+func (rw *rateWindow) count(now int64) float64 {
+	left := rw.start + rw.window - now
+	return float64(rw.prev)*float64(left)/float64(rw.window) + float64(rw.curr)
+}
+
+// retryAfter returns the number of milliseconds before one more hit fits the limit
+func (rw *rateWindow) retryAfter(now int64, limit int64) int64 {
+
+	// the hits of the current window are the previous ones in the next window
+	start, prev, free := rw.start, rw.prev, limit-1-rw.curr
+	if free < 0 {
+		start, prev, free = rw.start+rw.window, rw.curr, limit-1
+	}
+
+	if prev == 0 {
+		return start - now
+	}
+
+	// the weight of prev hits falls to free at the moment
+	at := start + rw.window - free*rw.window/prev
+	if at < now {
+		return 0
+	}
+
+	return at - now
+}
+
+// reset returns the time when no hits are left in the sliding window
+func (rw *rateWindow) reset(now int64) int64 {
+
+	switch {
+	case rw.curr > 0:
+		return rw.start + 2*rw.window
+	case rw.prev > 0:
+		return rw.start + rw.window
+	}
+
+	return now
+}
+
+// RateLimit records a hit of the rate limiter allowing limit hits per window
+// if the hit fits the limit. Denied hits are not recorded. Changing
+// the window of an existing limiter starts it over.
+func (s *Server) RateLimit(key string, limit int64, window time.Duration) (RateLimitResult, error) {
+	return s.rateLimit(key, limit, window, time.Now())
+}
+
+func (s *Server) rateLimit(key string, limit int64, window time.Duration,
+	t time.Time) (RateLimitResult, error) {
+
+	if err := s.validateKey(key); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	ms := int64(window / time.Millisecond)
+	if limit < 1 || ms < 1 || ms > MaxExpiresInMs {
+		return RateLimitResult{}, ErrRateLimitInvalid
+	}
+
+	now := t.UnixNano() / int64(time.Millisecond)
+	result := RateLimitResult{Limit: limit}
+
+	err := s.update(rateLimitKeyPrefix+key, func(rec *sdk.Record, ok bool) int8 {
+
+		rw, decoded := decodeRateWindow(rec.Value)
+		if !ok || !decoded || rw.window != ms {
+			rw = rateWindow{window: ms, start: now - now%ms}
+		}
+
+		rw.advance(now)
+
+		count := rw.count(now)
+		if result.Allowed = count+1 <= float64(limit); result.Allowed {
+			rw.curr++
+			count++
+		} else {
+			result.RetryAfter = ceilDiv(rw.retryAfter(now, limit), 1000)
+			if result.RetryAfter < 1 {
+				result.RetryAfter = 1
+			}
+		}
+
+		result.Remaining = limit - int64(count)
+		if result.Remaining < 0 {
+			result.Remaining = 0
+		}
+
+		result.Reset = ceilDiv(rw.reset(now), 1000)
+
+		if !result.Allowed {
+			return sdk.UpdateKeep
+		}
+
+		*rec = sdk.Record{
+			Expires: rw.start + 2*rw.window,
+			Value:   rw.encode(),
+		}
+
+		return sdk.UpdateStore
+	}, true)
+
+	return result, err
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// parseWindow parses the window query parameter given either as a duration
+// like "1m" or as a number of seconds
+func parseWindow(val string) (time.Duration, error) {
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		var sec int64
+		if sec, err = strconv.ParseInt(val, 10, 64); err != nil || sec > MaxExpiresInMs/1000 {
+			return 0, ErrRateLimitInvalid
+		}
+		d = time.Duration(sec) * time.Second
+	}
+
+	return d, nil
+}
+
+// Record the hit of the rate limiter by
+// POST /_ratelimit/somekey?limit=100&window=1m. Responds with 200 if the hit
+// is allowed and 429 otherwise, the body is JSON RateLimitResult in both cases.
+func (s *Server) rateLimitHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+
+	limit, err := strconv.ParseInt(query.Get("limit"), 10, 64)
+	if err != nil {
+		err = ErrRateLimitInvalid
+	}
+
+	var window time.Duration
+	if err == nil {
+		window, err = parseWindow(query.Get("window"))
+	}
+
+	var result RateLimitResult
+	if err == nil {
+		result, err = s.RateLimit(strings.TrimPrefix(r.URL.Path, rateLimitPath), limit, window)
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		log.Printf(requestInfo(t, http.StatusBadRequest, r, "error:%s", err.Error()))
+		return
+	}
+
+	code := http.StatusOK
+	if !result.Allowed {
+		code = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", strconv.FormatInt(result.RetryAfter, 10))
+	}
+
+	body, _ := json.Marshal(result)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+	w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(result.Reset, 10))
+	w.WriteHeader(code)
+	w.Write(body)
+	log.Printf(requestInfo(t, code, r, "remaining:%d", result.Remaining))
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+
+	s, _ := newTestServer()
+	// the limiter expires by the clock of the cache
+	start := time.Now().Truncate(time.Minute)
+
+	for i := int64(0); i < 3; i++ {
+		res, err := s.rateLimit("api", 3, time.Minute, start)
+		if err != nil || !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("hit %d = %+v, %v; wants allowed with %d remaining", i, res, err, 2-i)
+		}
+	}
+
+	res, _ := s.rateLimit("api", 3, time.Minute, start.Add(30*time.Second))
+	if res.Allowed || res.Remaining != 0 || res.RetryAfter != 50 {
+		t.Errorf("hit over the limit = %+v; wants denied with retry after 50s", res)
+	}
+
+	// 3 hits of the previous window are weighted by 1/3 at 40s of the next one
+	res, _ = s.rateLimit("api", 3, time.Minute, start.Add(100*time.Second))
+	if !res.Allowed || res.Remaining != 1 || res.Reset != start.Unix()+180 {
+		t.Errorf("hit in the next window = %+v; wants allowed with 1 remaining", res)
+	}
+
+	res, _ = s.rateLimit("api", 3, time.Minute, start.Add(10*time.Minute))
+	if !res.Allowed || res.Remaining != 2 {
+		t.Errorf("hit after idle windows = %+v; wants allowed with 2 remaining", res)
+	}
+
+	if _, err := s.rateLimit("api", 0, time.Minute, start); err != ErrRateLimitInvalid {
+		t.Errorf("rateLimit() with zero limit = %v; wants %v", err, ErrRateLimitInvalid)
+	}
+
+	if s.Exists(rateLimitKeyPrefix + "api") {
+		t.Errorf("rate limiter is available through operations on keys")
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type handlerFunc func(t time.Time, w http.ResponseWriter, r *http.Request)
+
+// A route serves either exactly one path or the whole subtree of paths
+// starting with the prefix.
+type route struct {
+	path     string
+	handlers map[string]handlerFunc
+}
+
+// The router dispatches requests to handlers by path and method.
+// Exact routes win over subtree routes and a longer subtree route wins over
+// a shorter one. Unsupported methods are responded with 405 and the list
+// of allowed methods, OPTIONS responds with the list only.
+type router struct {
+	exact   map[string]*route
+	subtree []*route // sorted by path length descending
+	onStart func()
+}
+
+func newRouter(onStart func()) *router {
+	return &router{
+		exact:   make(map[string]*route),
+		onStart: onStart,
+	}
+}
+
+// handle registers h for the exact path
+func (rt *router) handle(path string, h handlerFunc, methods ...string) {
+
+	x, ok := rt.exact[path]
+	if !ok {
+		x = &route{path: path, handlers: make(map[string]handlerFunc)}
+		rt.exact[path] = x
+	}
+
+	x.register(h, methods)
+}
+
+// handlePrefix registers h for every path starting with prefix
+func (rt *router) handlePrefix(prefix string, h handlerFunc, methods ...string) {
+
+	var x *route
+
+	for _, y := range rt.subtree {
+		if y.path == prefix {
+			x = y
+		}
+	}
+
+	if x == nil {
+		x = &route{path: prefix, handlers: make(map[string]handlerFunc)}
+		rt.subtree = append(rt.subtree, x)
+		sort.SliceStable(rt.subtree, func(i, j int) bool {
+			return len(rt.subtree[i].path) > len(rt.subtree[j].path)
+		})
+	}
+
+	x.register(h, methods)
+}
+
+func (x *route) register(h handlerFunc, methods []string) {
+
+	for _, method := range methods {
+		x.handlers[method] = h
+	}
+}
+
+func (rt *router) match(path string) *route {
+
+	if x, ok := rt.exact[path]; ok {
+		return x
+	}
+
+	for _, x := range rt.subtree {
+		if strings.HasPrefix(path, x.path) {
+			return x
+		}
+	}
+
+	return nil
+}
+
+func (x *route) allow() string {
+
+	methods := []string{http.MethodOptions}
+	for method := range x.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	return strings.Join(methods, ", ")
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	start := time.Now()
+	if rt.onStart != nil {
+		rt.onStart()
+	}
+
+	x := rt.match(r.URL.Path)
+	if x == nil {
+		http.NotFound(w, r)
+		log.Printf(requestInfo(start, http.StatusNotFound, r, ""))
+		return
+	}
+
+	if h, ok := x.handlers[r.Method]; ok {
+		h(start, w, r)
+		return
+	}
+
+	w.Header().Set("Allow", x.allow())
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		log.Printf(requestInfo(start, http.StatusNoContent, r, ""))
+		return
+	}
+
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	log.Printf(requestInfo(start, http.StatusMethodNotAllowed, r, ""))
+}
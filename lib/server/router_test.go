@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func namedHandler(name string) handlerFunc {
+	return func(t time.Time, w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}
+}
+
+func testRouter() *router {
+	rt := newRouter(nil)
+
+	rt.handle("/", namedHandler("health"), http.MethodGet)
+	rt.handlePrefix("/", namedHandler("key"), http.MethodGet, http.MethodPut)
+	rt.handlePrefix("/_svc/", namedHandler("svc"), http.MethodPost)
+
+	return rt
+}
+
+func TestRouterMatch(t *testing.T) {
+	rt := testRouter()
+
+	table := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+		allow  string
+	}{
+		{http.MethodGet, "/", http.StatusOK, "health", ""},
+		{http.MethodGet, "/a/b", http.StatusOK, "key", ""},
+		{http.MethodPut, "/a", http.StatusOK, "key", ""},
+		{http.MethodPost, "/_svc/x", http.StatusOK, "svc", ""},
+		{http.MethodPost, "/", http.StatusMethodNotAllowed, "", "GET, OPTIONS"},
+		{http.MethodDelete, "/a", http.StatusMethodNotAllowed, "", "GET, OPTIONS, PUT"},
+		{http.MethodOptions, "/_svc/x", http.StatusNoContent, "", "OPTIONS, POST"},
+	}
+
+	for _, x := range table {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(x.method, x.path, nil))
+
+		if w.Code != x.code {
+			t.Errorf("%s %s code = %d; wants %d", x.method, x.path, w.Code, x.code)
+		}
+
+		if w.Body.String() != x.body {
+			t.Errorf("%s %s body = %q; wants %q", x.method, x.path, w.Body.String(), x.body)
+		}
+
+		if got := w.Header().Get("Allow"); got != x.allow {
+			t.Errorf("%s %s Allow = %q; wants %q", x.method, x.path, got, x.allow)
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"math"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/iaroslavscript/cacheman/lib/config"
@@ -18,13 +22,30 @@ import (
 )
 
 const metricsSubsystem = "server"
+const flushPath = "/_admin/flush"
+
+// The time Close waits for active requests, long polls and watch streams
+// are closed after it
+const shutdownTimeout = 5 * time.Second
 
 type Server struct {
-	cache               *sdk.Cache
-	cfg                 *config.Config
-	repl                *sdk.Replication
-	sched               *sdk.Scheduler
-	opsApiRequestsTotal prometheus.Counter
+	waiters                int64 // first to be 64-bit aligned for atomic operations
+	cache                  *sdk.Cache
+	cfg                    *config.Config
+	channels               *eventHub
+	events                 *eventHub
+	fillMu                 sync.Mutex // held while fill tokens are given or checked
+	flights                flightGroup
+	http                   *http.Server
+	keyPattern             *regexp.Regexp
+	originClient           *http.Client
+	repl                   *sdk.Replication
+	sched                  *sdk.Scheduler
+	opsApiRequestsTotal    prometheus.Counter
+	opsPublishedTotal      prometheus.Counter
+	opsWaiters             prometheus.Gauge
+	opsOriginRequestsTotal prometheus.Counter
+	opsOriginErrorsTotal   prometheus.Counter
 }
 
 func pathToKey(path string) string {
@@ -35,6 +56,27 @@ func pathToKey(path string) string {
 	return ""
 }
 
+func (s *Server) validateKey(key string) error {
+
+	if key == "" {
+		return errors.New("Key should not be empty")
+	}
+
+	if int64(len(key)) > s.cfg.MaxKeyLength {
+		return fmt.Errorf("Key is longer than %d bytes", s.cfg.MaxKeyLength)
+	}
+
+	if sdk.IsReserved(key) {
+		return ErrKeyReserved
+	}
+
+	if !s.keyPattern.MatchString(key) {
+		return fmt.Errorf("Key doesn't match pattern %s", s.cfg.KeyPattern)
+	}
+
+	return nil
+}
+
 func requestInfo(start time.Time, code int, r *http.Request,
 	f string, args ...interface{}) string {
 
@@ -50,38 +92,55 @@ func requestInfo(start time.Time, code int, r *http.Request,
 	)
 }
 
-func (s *Server) dataHandler(w http.ResponseWriter, r *http.Request) {
+// Routes of service endpoints. Every other path is treated as a key.
+func (s *Server) routes() *router {
+
+	rt := newRouter(s.opsApiRequestsTotal.Inc)
+
+	rt.handle("/", s.healthHandler, http.MethodGet, http.MethodHead)
+	rt.handle(flushPath, s.flushHandler, http.MethodPost)
+	rt.handlePrefix(flushPath+"/", s.flushHandler, http.MethodPost)
+	rt.handle(watchPath, s.watchHandler, http.MethodGet)
+	rt.handlePrefix(pubsubPath, s.withValidKey(pathToChannel, s.publishHandler), http.MethodPost)
+	rt.handlePrefix(pubsubPath, s.withValidKey(pathToChannel, s.channelHandler), http.MethodGet)
+	rt.handlePrefix(hashPath, s.withValidKey(pathToHash, s.getFieldHandler), http.MethodGet)
+	rt.handlePrefix(hashPath, s.withValidKey(pathToHash, s.setFieldHandler), http.MethodPost, http.MethodPut)
+	rt.handlePrefix(hashPath, s.withValidKey(pathToHash, s.deleteFieldHandler), http.MethodDelete)
+	rt.handlePrefix(listPath, s.withValidKey(pathToList, s.rangeHandler), http.MethodGet)
+	rt.handlePrefix(listPath, s.withValidKey(pathToList, s.listHandler), http.MethodPost)
+	rt.handlePrefix(listPath, s.withValidKey(pathToList, s.deleteListHandler), http.MethodDelete)
+	rt.handlePrefix(zsetPath, s.withValidKey(pathToZset, s.getZsetHandler), http.MethodGet)
+	rt.handlePrefix(zsetPath, s.withValidKey(pathToZset, s.addZsetHandler), http.MethodPost)
+	rt.handlePrefix(zsetPath, s.withValidKey(pathToZset, s.deleteZsetHandler), http.MethodDelete)
+	rt.handlePrefix(lockPath, s.withValidKey(pathToLock, s.acquireLockHandler), http.MethodPost)
+	rt.handlePrefix(lockPath, s.withValidKey(pathToLock, s.renewLockHandler), http.MethodPut)
+	rt.handlePrefix(lockPath, s.withValidKey(pathToLock, s.releaseLockHandler), http.MethodDelete)
+	rt.handlePrefix(lockPath, s.withValidKey(pathToLock, s.lockInfoHandler), http.MethodGet)
+	rt.handlePrefix(rateLimitPath, s.rateLimitHandler, http.MethodPost)
+
+	rt.handlePrefix("/", s.withValidKey(pathToKey, s.lookupHandler), http.MethodGet)
+	rt.handlePrefix("/", s.withValidKey(pathToKey, s.existsHandler), http.MethodHead)
+	rt.handlePrefix("/", s.withValidKey(pathToKey, s.insertHandler), http.MethodPost, http.MethodPut)
+	rt.handlePrefix("/", s.withValidKey(pathToKey, s.deleteHandler), http.MethodDelete)
+
+	return rt
+}
 
-	start := time.Now()
-	s.opsApiRequestsTotal.Inc()
+// withValidKey responds with 400 if the key the path is trimmed to by trim
+// is invalid. The same rules apply to keys, hashes, lists, sorted sets,
+// locks and channels.
+func (s *Server) withValidKey(trim func(string) string, h handlerFunc) handlerFunc {
 
-	if r.URL.Path == "/" {
+	return func(t time.Time, w http.ResponseWriter, r *http.Request) {
 
-		switch r.Method {
-		case http.MethodGet:
-			s.healthHandler(start, w, r)
-		case http.MethodHead:
-			s.healthHandler(start, w, r)
-		default:
+		if err := s.validateKey(trim(r.URL.Path)); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			log.Printf(requestInfo(start, http.StatusBadRequest, r, ""))
+			w.Write([]byte(err.Error()))
+			log.Printf(requestInfo(t, http.StatusBadRequest, r, "error:%s", err.Error()))
+			return
 		}
-	} else {
 
-		switch r.Method {
-
-		case http.MethodGet:
-			s.lookupHandler(start, w, r)
-		case http.MethodPost:
-			s.insertHandler(start, w, r)
-		case http.MethodHead:
-			s.existsHandler(start, w, r)
-		case http.MethodDelete:
-			s.deleteHandler(start, w, r)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-			log.Printf(requestInfo(start, http.StatusBadRequest, r, ""))
-		}
+		h(t, w, r)
 	}
 }
 
@@ -93,12 +152,7 @@ func (s *Server) healthHandler(t time.Time, w http.ResponseWriter, r *http.Reque
 
 func (s *Server) existsHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
 
-	key := sdk.KeyInfo{
-		Expires: time.Now().Unix(),
-		Key:     pathToKey(r.URL.Path),
-	}
-
-	if _, ok := (*s.cache).Lookup(key); !ok {
+	if !s.Exists(pathToKey(r.URL.Path)) {
 
 		http.NotFound(w, r)
 		log.Printf(requestInfo(t, http.StatusNotFound, r, ""))
@@ -109,64 +163,117 @@ func (s *Server) existsHandler(t time.Time, w http.ResponseWriter, r *http.Reque
 	log.Printf(requestInfo(t, http.StatusOK, r, ""))
 }
 
+// hasHeaderContentExpires reports whether the request sets the expiration time
+func hasHeaderContentExpires(r *http.Request) bool {
+	return r.Header.Get("X-Content-Expires-Ms") != "" || r.Header.Get("X-Content-Expires-Sec") != ""
+}
+
+// parseHeaderContentExpires returns the number of milliseconds the value
+// expires in. Header X-Content-Expires-Ms takes precedence over
+// X-Content-Expires-Sec.
 func (s *Server) parseHeaderContentExpires(r *http.Request) (int64, error) {
-	var expires_in_sec int64
+	var expires_in_ms int64
 	var e error
 
 	if val := r.Header.Get("X-Content-Expires-At"); val != "" {
 		// no-op
 		// TODO for next version
+	} else if val = r.Header.Get("X-Content-Expires-Ms"); val != "" {
+
+		valint, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || valint > MaxExpiresInMs {
+			e = errors.New(fmt.Sprintf("Improper value of %s http header",
+				"X-Content-Expires-Ms",
+			))
+			return expires_in_ms, e
+		}
+
+		expires_in_ms = valint
 	} else if val = r.Header.Get("X-Content-Expires-Sec"); val != "" {
 
-		valint, err := strconv.Atoi(val)
-		if err != nil {
+		valint, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || valint > MaxExpiresInMs/1000 {
 			e = errors.New(fmt.Sprintf("Improper value of %s http header",
 				"X-Content-Expires-Sec",
 			))
-			return expires_in_sec, e
+			return expires_in_ms, e
 		}
 
-		expires_in_sec = int64(valint)
+		expires_in_ms = valint * 1000
 	} else {
 
-		expires_in_sec = s.cfg.ExpiresDefaultDurationSec
+		expires_in_ms = s.cfg.ExpiresDefaultDurationSec * 1000
 	}
 
-	if expires_in_sec < 1 {
-		e = errors.New(fmt.Sprintf("Improper value of %s or %s HTTP header",
+	if expires_in_ms < 1 {
+		e = errors.New(fmt.Sprintf("Improper value of %s, %s or %s HTTP header",
+			"X-Content-Expires-Ms",
 			"X-Content-Expires-Sec",
 			"X-Content-Expires-At",
 		))
 	}
 
-	return expires_in_sec, e
+	return expires_in_ms, e
 }
 
 func (s *Server) deleteHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
 
-	key := sdk.KeyInfo{
-		Expires: math.MaxInt64, // remove record regardless of it's expires date
-		Key:     pathToKey(r.URL.Path),
-	}
-
-	// We need to delete only if keys was found to avoid overpopulation of
-	// replication log's bucket.
-	// It's better to move it to cache or because we missing sheduler deletion
-	//(*s.repl).Delete(*sdk.NewReplItem(0, keyinfo, rec))
-
-	(*s.cache).Delete(key)
+	s.Delete(pathToKey(r.URL.Path))
 	w.WriteHeader(http.StatusOK)
 	log.Printf(requestInfo(t, http.StatusOK, r, ""))
 }
 
+// Lookup for the key. With parameter wait the request blocks until the key
+// exists or, if header If-None-Match is set, until the value changes.
 func (s *Server) lookupHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
 
-	key := sdk.KeyInfo{
-		Expires: time.Now().Unix(),
-		Key:     pathToKey(r.URL.Path),
+	key := pathToKey(r.URL.Path)
+	ifNoneMatch := r.Header.Get("If-None-Match")
+
+	wait, err := s.parseWait(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		log.Printf(requestInfo(t, http.StatusBadRequest, r, "error:%s", err.Error()))
+		return
+	}
+
+	var rec sdk.Record
+	var ok bool
+
+	switch {
+	case r.URL.Query()["fill"] != nil:
+		if wait == 0 {
+			wait = time.Duration(s.cfg.FillWaitMs) * time.Millisecond
+		}
+
+		// the first client missing the key gets the token to populate it
+		var token string
+		if rec, ok, token, err = s.LookupOrFill(r.Context(), key, wait); token != "" {
+			w.Header().Set("X-Fill-Token", token)
+		}
+	case wait > 0:
+		rec, ok, err = s.waitRecord(r.Context(), key, ifNoneMatch, wait)
+	default:
+		// keys served by an origin are loaded on miss
+		rec, ok, err = s.Load(key)
+	}
+
+	if oerr, isOrigin := err.(*OriginError); isOrigin {
+		w.WriteHeader(oerr.Code)
+		w.Write([]byte(oerr.Message))
+		log.Printf(requestInfo(t, oerr.Code, r, "error:%s", oerr.Message))
+		return
+	} else if err == ErrTooManyWaiters {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		log.Printf(requestInfo(t, http.StatusServiceUnavailable, r, "error:%s", err.Error()))
+		return
+	} else if err != nil {
+		log.Printf(requestInfo(t, statusClientClosedRequest, r, "error:%s", err.Error()))
+		return
 	}
 
-	rec, ok := (*s.cache).Lookup(key)
 	if !ok {
 
 		http.NotFound(w, r)
@@ -174,6 +281,26 @@ func (s *Server) lookupHandler(t time.Time, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if !rec.IsPlain() {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(ErrWrongType.Error()))
+		log.Printf(requestInfo(t, http.StatusConflict, r, "error:%s", ErrWrongType.Error()))
+		return
+	}
+
+	etag := recordETag(rec)
+	w.Header().Set("ETag", etag)
+
+	if IsStale(rec, sdk.NowMs()) {
+		w.Header().Set("X-Cache-Stale", "true")
+	}
+
+	if etagMatch(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		log.Printf(requestInfo(t, http.StatusNotModified, r, ""))
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write(rec.Value)
@@ -184,9 +311,38 @@ func (s *Server) insertHandler(t time.Time, w http.ResponseWriter, r *http.Reque
 	key := pathToKey(r.URL.Path)
 	var value []byte
 	var err error
-	var expires_in_sec int64
+	var expires_in_ms int64
+	var ok bool
+
+	if expires_in_ms, err = s.parseHeaderContentExpires(r); err != nil {
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		log.Printf(requestInfo(t, http.StatusBadRequest, r, "error:%s", err.Error()))
+		return
+	}
+
+	if value, ok = s.readValue(t, w, r); !ok {
+		return
+	}
 
-	if expires_in_sec, err = s.parseHeaderContentExpires(r); err != nil {
+	expires_in_ms = s.jitter(key, expires_in_ms)
+
+	token := r.Header.Get("X-Fill-Token")
+	staleMs, err := parseHeaderStale(r)
+
+	if err == nil && (token != "" || staleMs > 0) {
+		err = s.Fill(key, value, expires_in_ms, staleMs, token)
+	} else if err == nil {
+		_, err = s.Set(key, value, expires_in_ms, SetAlways)
+	}
+
+	if err == ErrFillTokenStale {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		log.Printf(requestInfo(t, http.StatusConflict, r, "error:%s", err.Error()))
+		return
+	} else if err != nil {
 
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -194,9 +350,26 @@ func (s *Server) insertHandler(t time.Time, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	expires := time.Now().Unix() + expires_in_sec
+	log.Printf(requestInfo(t, http.StatusOK, r, "expires_ms:%d", expires_in_ms))
+	w.WriteHeader(http.StatusOK)
+}
+
+// readValue reads the body of the request not larger than max_value_bytes.
+// The response is written if the body can't be read.
+func (s *Server) readValue(t time.Time, w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var value []byte
+	var err error
+
+	if r.ContentLength > s.cfg.MaxValueBytes {
+		s.tooLargeHandler(t, w, r, r.ContentLength)
+		return nil, false
+	}
+
+	// read one byte over the limit to detect too large chunked bodies
+	// without buffering them
+	body := io.LimitReader(r.Body, s.cfg.MaxValueBytes+1)
 
-	if value, err = ioutil.ReadAll(r.Body); err != nil {
+	if value, err = ioutil.ReadAll(body); err != nil {
 		value_n := len(value)
 		msg := fmt.Sprintf("Received incomplete %s, size %d",
 			err.Error(),
@@ -209,30 +382,55 @@ func (s *Server) insertHandler(t time.Time, w http.ResponseWriter, r *http.Reque
 			msg,
 			value_n,
 		))
-		return
+		return nil, false
 	}
 
-	keyinfo := sdk.KeyInfo{
-		Expires: expires,
-		Key:     key,
+	if int64(len(value)) > s.cfg.MaxValueBytes {
+		s.tooLargeHandler(t, w, r, int64(len(value)))
+		return nil, false
 	}
 
-	rec := sdk.NewRecord(expires, value) // TODO remove unnessasery copy of []bytes here
+	return value, true
+}
+
+func (s *Server) tooLargeHandler(t time.Time, w http.ResponseWriter, r *http.Request, size int64) {
+
+	msg := fmt.Sprintf("Value is larger than %d bytes", s.cfg.MaxValueBytes)
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write([]byte(msg))
+	log.Printf(requestInfo(t, http.StatusRequestEntityTooLarge, r,
+		"error:'%s' size: %d",
+		msg,
+		size,
+	))
+}
+
+// Flush the whole cache by POST /_admin/flush or only one namespace
+// by POST /_admin/flush/namespace
+func (s *Server) flushHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
 
-	(*s.cache).Insert(keyinfo, *rec)                  // TODO remove unnessasery copy of []bytes here
-	(*s.repl).Add(*sdk.NewReplItem(0, keyinfo, *rec)) // TODO remove unnessasery copy of []bytes here
-	(*s.sched).Add(keyinfo)
+	namespace := strings.Trim(strings.TrimPrefix(r.URL.Path, flushPath), "/")
+
+	s.Flush(namespace)
 
-	log.Printf(requestInfo(t, http.StatusOK, r, "expires_sec:%d", expires_in_sec))
 	w.WriteHeader(http.StatusOK)
+	log.Printf(requestInfo(t, http.StatusOK, r, "namespace:'%s'", namespace))
 }
 
 func NewServer(cfg *config.Config, cache sdk.Cache,
 	repl sdk.Replication, sched sdk.Scheduler) *Server {
 
 	s := Server{
-		cache: &cache,
-		cfg:   cfg,
+		cache:      &cache,
+		cfg:        cfg,
+		channels:   newEventHub(cfg, "pubsub"),
+		events:     newEventHub(cfg, "watch"),
+		flights:    flightGroup{calls: make(map[string]*flight)},
+		keyPattern: regexp.MustCompile(cfg.KeyPattern), // checked by config.Validate()
+		http:       &http.Server{Addr: cfg.BindAddr},
+		originClient: &http.Client{
+			Timeout: time.Duration(cfg.OriginTimeoutMs) * time.Millisecond,
+		},
 		repl:  &repl,
 		sched: &sched,
 		opsApiRequestsTotal: promauto.NewCounter(prometheus.CounterOpts{
@@ -241,17 +439,60 @@ func NewServer(cfg *config.Config, cache sdk.Cache,
 			Name:      "api_requests_total",
 			Help:      "The total number of processed events",
 		}),
+		opsPublishedTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "pubsub_published_total",
+			Help:      "The total number of messages published to pub/sub channels",
+		}),
+		opsWaiters: promauto.NewGauge(prometheus.GaugeOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "waiters",
+			Help:      "The number of requests waiting for keys",
+		}),
+		opsOriginRequestsTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "origin_requests_total",
+			Help:      "The total number of requests to origins",
+		}),
+		opsOriginErrorsTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "origin_errors_total",
+			Help:      "The total number of failed requests to origins",
+		}),
 	}
 
 	s.opsApiRequestsTotal.Add(0.0)
+	s.opsPublishedTotal.Add(0.0)
+	s.opsWaiters.Set(0.0)
+	s.opsOriginRequestsTotal.Add(0.0)
+	s.opsOriginErrorsTotal.Add(0.0)
 
 	return &s
 }
 
+// Serve accepts requests until Close is called
 func (s *Server) Serve() error {
 
-	http.HandleFunc("/", s.dataHandler)
+	s.http.Handler = s.routes()
 
 	log.Printf("server start listenning at %s", s.cfg.BindAddr)
-	return http.ListenAndServe(s.cfg.BindAddr, nil)
+	return s.http.ListenAndServe()
+}
+
+// Close stops accepting requests and waits for the active ones
+// up to shutdownTimeout
+func (s *Server) Close() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := s.http.Shutdown(ctx); err != nil {
+		return s.http.Close()
+	}
+
+	return nil
 }
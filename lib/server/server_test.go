@@ -0,0 +1,137 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// Components of the test server keeping everything in memory
+
+type testCache struct {
+	m       sync.Mutex
+	data    map[string]sdk.Record
+	waiting map[string][]chan struct{}
+}
+
+// notify wakes up waiters of the key, it's called under the lock
+func (c *testCache) notify(key string) {
+	for _, ch := range c.waiting[key] {
+		close(ch)
+	}
+	delete(c.waiting, key)
+}
+
+func (c *testCache) Insert(key sdk.KeyInfo, rec sdk.Record) {
+	c.Update(key, func(cur *sdk.Record, ok bool) int8 {
+		*cur = rec
+		return sdk.UpdateStore
+	})
+}
+
+func (c *testCache) Lookup(key sdk.KeyInfo) (sdk.Record, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	rec, ok := c.data[key.Key]
+	return rec, ok && rec.Expires > key.Expires
+}
+
+func (c *testCache) Delete(key sdk.KeyInfo) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	_, ok := c.data[key.Key]
+	delete(c.data, key.Key)
+	c.notify(key.Key)
+	return ok
+}
+
+func (c *testCache) Update(key sdk.KeyInfo, fn sdk.UpdateFunc) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	rec, ok := c.data[key.Key]
+	ok = ok && rec.Expires > key.Expires
+	if !ok {
+		rec = sdk.Record{}
+	}
+
+	switch fn(&rec, ok) {
+	case sdk.UpdateStore:
+		c.data[key.Key] = rec
+		c.notify(key.Key)
+	case sdk.UpdateDelete:
+		delete(c.data, key.Key)
+		c.notify(key.Key)
+	}
+}
+
+func (c *testCache) Wait(key string) (<-chan struct{}, func()) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.waiting == nil {
+		c.waiting = make(map[string][]chan struct{})
+	}
+
+	ch := make(chan struct{})
+	c.waiting[key] = append(c.waiting[key], ch)
+
+	// the channel is left until the next notification
+	return ch, func() {}
+}
+
+func (c *testCache) Flush(namespace string) {}
+
+type testReplication struct {
+	m     sync.Mutex
+	items []sdk.ReplItem
+}
+
+func (r *testReplication) Add(item sdk.ReplItem) {
+	r.m.Lock()
+	r.items = append(r.items, item)
+	r.m.Unlock()
+}
+
+type testScheduler struct {
+	ch       chan []sdk.KeyInfo
+	m        sync.Mutex
+	canceled []sdk.KeyInfo
+}
+
+func (s *testScheduler) Add(key sdk.KeyInfo) {}
+
+func (s *testScheduler) Cancel(key sdk.KeyInfo) {
+	s.m.Lock()
+	s.canceled = append(s.canceled, key)
+	s.m.Unlock()
+}
+
+func (s *testScheduler) GetChan() *chan []sdk.KeyInfo { return &s.ch }
+func (s *testScheduler) Flush(namespace string)       {}
+
+var testServer *Server
+var testServerRepl *testReplication
+var testServerSched *testScheduler
+var testServerOnce sync.Once
+
+// newTestServer returns the server shared by tests because metrics
+// can be registered only once
+func newTestServer() (*Server, *testReplication) {
+
+	testServerOnce.Do(func() {
+		cfg := *config.GetConfig()
+		testServerRepl = &testReplication{}
+		testServerSched = &testScheduler{}
+		testServer = NewServer(&cfg,
+			&testCache{data: make(map[string]sdk.Record)},
+			testServerRepl,
+			testServerSched,
+		)
+	})
+
+	return testServer, testServerRepl
+}
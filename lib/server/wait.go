@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// The status logged when the client closes the connection before the response
+// is ready. It's never sent to the client.
+const statusClientClosedRequest = 499
+
+var ErrTooManyWaiters = errors.New("Too many clients are waiting for keys")
+
+// recordETag returns the entity tag of the record. A new record id is assigned
+// on every modification so it's a good version of the value.
+func recordETag(rec sdk.Record) string {
+	return `"` + strconv.FormatUint(rec.GetRecId(), 10) + `"`
+}
+
+// etagMatch reports whether the If-None-Match header matches the entity tag
+func etagMatch(header string, etag string) bool {
+
+	if header == "" {
+		return false
+	}
+
+	for _, x := range strings.Split(header, ",") {
+		x = strings.TrimPrefix(strings.TrimSpace(x), "W/")
+		if x == "*" || x == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseWait parses the wait query parameter given either as a duration
+// like "30s" or as a number of seconds. The result is limited by
+// max_wait_sec.
+func (s *Server) parseWait(r *http.Request) (time.Duration, error) {
+
+	val := r.URL.Query().Get("wait")
+	if val == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		var sec int64
+		if sec, err = strconv.ParseInt(val, 10, 64); err != nil {
+			return 0, fmt.Errorf("Improper value of wait parameter")
+		}
+		d = time.Duration(sec) * time.Second
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("Improper value of wait parameter")
+	}
+
+	if max := time.Duration(s.cfg.MaxWaitSec) * time.Second; d > max {
+		d = max
+	}
+
+	return d, nil
+}
+
+// waitRecord blocks until the key exists or, if etag is set, until the key
+// is deleted or its entity tag doesn't match etag. It returns the latest state
+// of the record after the wait time elapses or ctx is done too.
+func (s *Server) waitRecord(ctx context.Context, key string, etag string,
+	wait time.Duration) (sdk.Record, bool, error) {
+
+	var rec sdk.Record
+	var ok bool
+
+	err := s.waitFor(ctx, key, wait, func() bool {
+		rec, ok = s.Get(key)
+		if !ok {
+			// the deleted key is a change of the version known to the client
+			return etag != ""
+		}
+		return !etagMatch(etag, recordETag(rec))
+	})
+
+	return rec, ok, err
+}
+
+// Waiters returns the number of callers waiting for keys
+func (s *Server) Waiters() int64 {
+	return atomic.LoadInt64(&s.waiters)
+}
+
+// waitFor calls try every time the key is stored or deleted until try reports true,
+// the wait time elapses or ctx is done. The number of callers waiting
+// at the same time is limited by max_waiters.
+func (s *Server) waitFor(ctx context.Context, key string, wait time.Duration, try func() bool) error {
+
+	if atomic.AddInt64(&s.waiters, 1) > s.cfg.MaxWaiters {
+		atomic.AddInt64(&s.waiters, -1)
+		return ErrTooManyWaiters
+	}
+
+	s.opsWaiters.Inc()
+	defer func() {
+		atomic.AddInt64(&s.waiters, -1)
+		s.opsWaiters.Dec()
+	}()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		// start waiting before the try to not miss the insert between them
+		ch, cancel := (*s.cache).Wait(key)
+
+		if try() {
+			cancel()
+			return nil
+		}
+
+		select {
+		case <-ch:
+		case <-timer.C:
+			cancel()
+			return nil
+		case <-ctx.Done():
+			cancel()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type waitResult struct {
+	value string
+	ok    bool
+	err   error
+}
+
+// startWait runs waitRecord and returns once the server counts the waiter
+func startWait(t *testing.T, s *Server, ctx context.Context, key, etag string, wait time.Duration) chan waitResult {
+
+	n := atomic.LoadInt64(&s.waiters)
+	done := make(chan waitResult, 1)
+
+	go func() {
+		rec, ok, err := s.waitRecord(ctx, key, etag, wait)
+		done <- waitResult{string(rec.Value), ok, err}
+	}()
+
+	for i := 0; atomic.LoadInt64(&s.waiters) == n; i++ {
+		if i == 1000 {
+			t.Fatalf("waitRecord(%s) doesn't start waiting", key)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return done
+}
+
+func receiveWait(t *testing.T, done chan waitResult, key string) waitResult {
+
+	select {
+	case x := <-done:
+		return x
+	case <-time.After(5 * time.Second):
+		t.Fatalf("waitRecord(%s) isn't woken up", key)
+	}
+
+	return waitResult{}
+}
+
+func TestWaitRecordInsert(t *testing.T) {
+
+	s, _ := newTestServer()
+	ctx := context.Background()
+
+	done := startWait(t, s, ctx, "wait/insert", "", time.Minute)
+	s.Set("wait/insert", []byte("x"), 0, SetAlways)
+
+	if x := receiveWait(t, done, "wait/insert"); x.err != nil || !x.ok || x.value != "x" {
+		t.Errorf("waitRecord() of inserted key = %q, %v, %v; wants x", x.value, x.ok, x.err)
+	}
+}
+
+func TestWaitRecordETag(t *testing.T) {
+
+	s, _ := newTestServer()
+	ctx := context.Background()
+
+	s.Set("wait/etag", []byte("x"), 0, SetAlways)
+	rec, _ := s.Get("wait/etag")
+	etag := recordETag(rec)
+
+	// the key exists without the etag so the wait ends at once
+	if rec, ok, err := s.waitRecord(ctx, "wait/etag", "", time.Minute); err != nil || !ok || string(rec.Value) != "x" {
+		t.Errorf("waitRecord() of existing key = %q, %v, %v; wants x", rec.Value, ok, err)
+	}
+
+	done := startWait(t, s, ctx, "wait/etag", etag, time.Minute)
+	s.Set("wait/etag", []byte("y"), 0, SetAlways)
+
+	if x := receiveWait(t, done, "wait/etag"); x.err != nil || !x.ok || x.value != "y" {
+		t.Errorf("waitRecord() of changed key = %q, %v, %v; wants y", x.value, x.ok, x.err)
+	}
+
+	rec, _ = s.Get("wait/etag")
+	done = startWait(t, s, ctx, "wait/etag", recordETag(rec), time.Minute)
+	s.Delete("wait/etag")
+
+	if x := receiveWait(t, done, "wait/etag"); x.err != nil || x.ok {
+		t.Errorf("waitRecord() of deleted key = %q, %v, %v; wants miss", x.value, x.ok, x.err)
+	}
+}
+
+func TestWaitRecordTimeout(t *testing.T) {
+
+	s, _ := newTestServer()
+
+	start := time.Now()
+	_, ok, err := s.waitRecord(context.Background(), "wait/timeout", "", 20*time.Millisecond)
+	if err != nil || ok {
+		t.Errorf("waitRecord() of absent key = %v, %v; wants miss", ok, err)
+	}
+
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("waitRecord() returns in %s; wants 20ms", d)
+	}
+
+	// the deletion of other keys doesn't end the wait
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startWait(t, s, ctx, "wait/timeout", "", time.Minute)
+	s.Set("wait/other", []byte("x"), 0, SetAlways)
+	s.Delete("wait/other")
+	cancel()
+
+	if x := receiveWait(t, done, "wait/timeout"); x.err != context.Canceled {
+		t.Errorf("waitRecord() of canceled request error %v; wants %v", x.err, context.Canceled)
+	}
+}
+
+func TestWaitRecordTooManyWaiters(t *testing.T) {
+
+	s, _ := newTestServer()
+
+	maxWaiters := s.cfg.MaxWaiters
+	s.cfg.MaxWaiters = 1
+	defer func() { s.cfg.MaxWaiters = maxWaiters }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startWait(t, s, ctx, "wait/many", "", time.Minute)
+
+	if _, _, err := s.waitRecord(context.Background(), "wait/many", "", time.Minute); err != ErrTooManyWaiters {
+		t.Errorf("waitRecord() over max_waiters error %v; wants %v", err, ErrTooManyWaiters)
+	}
+
+	cancel()
+	receiveWait(t, done, "wait/many")
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+const watchPath = "/_watch"
+
+// The period of comments sent to keep idle connections open
+const watchHeartbeat = 15 * time.Second
+
+var watchEventNames = map[int8]string{
+	sdk.ReplActionInsert:       "set",
+	sdk.ReplActionDelete:       "delete",
+	sdk.ReplActionFlush:        "flush",
+	sdk.ReplActionExpire:       "expire",
+	sdk.ReplActionHashSet:      "hset",
+	sdk.ReplActionHashDelete:   "hdel",
+	sdk.ReplActionListPushHead: "lpush",
+	sdk.ReplActionListPushTail: "rpush",
+	sdk.ReplActionListPopHead:  "lpop",
+	sdk.ReplActionListPopTail:  "rpop",
+	sdk.ReplActionListTrim:     "ltrim",
+	sdk.ReplActionZsetAdd:      "zadd",
+	sdk.ReplActionZsetRemove:   "zrem",
+}
+
+type watchEvent struct {
+	Key       string   `json:"key"`
+	Field     string   `json:"field,omitempty"`
+	Value     []byte   `json:"value,omitempty"`
+	Score     *float64 `json:"score,omitempty"`
+	Expires   int64    `json:"expires,omitempty"`    // unix time in seconds
+	ExpiresMs int64    `json:"expires_ms,omitempty"` // unix time in milliseconds
+}
+
+// writeEvent writes one Server-Sent Event
+func writeEvent(w http.ResponseWriter, id uint64, name string, data interface{}) error {
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id > 0 {
+		fmt.Fprintf(w, "id: %d\n", id)
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, body)
+	return err
+}
+
+// Stream changes of keys as Server-Sent Events.
+// GET /_watch?key=somekey watches one key,
+// GET /_watch?prefix=someprefix watches keys starting with the prefix.
+func (s *Server) watchHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	var sub *Subscription
+	var err error
+	query := r.URL.Query()
+
+	if key := query.Get("key"); key != "" {
+		sub, err = s.SubscribeKey(key)
+	} else {
+		sub, err = s.Subscribe(query.Get("prefix"))
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		log.Printf(requestInfo(t, http.StatusBadRequest, r, "error:%s", err.Error()))
+		return
+	}
+
+	streamEvents(t, w, r, sub, func(item sdk.ReplItem) (uint64, string, interface{}) {
+
+		event := watchEvent{Key: item.Key.Key, Field: item.Field}
+		if item.Action == sdk.ReplActionZsetAdd {
+			event.Score = &item.Score
+		}
+
+		if item.HasValue() {
+			event.Value = item.Value.Value
+			event.Expires = item.Value.Expires / 1000
+			event.ExpiresMs = item.Value.Expires
+		}
+
+		return item.Value.GetRecId(), watchEventNames[item.Action], event
+	})
+}
+
+// streamEvents sends items of the subscription as Server-Sent Events encoded
+// by encode until the client disconnects. The subscription is closed on return.
+func streamEvents(t time.Time, w http.ResponseWriter, r *http.Request, sub *Subscription,
+	encode func(item sdk.ReplItem) (id uint64, name string, data interface{})) {
+
+	defer sub.Close()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf(requestInfo(t, http.StatusInternalServerError, r, "error:streaming unsupported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	log.Printf(requestInfo(t, http.StatusOK, r, "stream:started"))
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	var err error
+	for err == nil {
+		select {
+		case item, ok := <-sub.C:
+			if !ok {
+				writeEvent(w, 0, "error", map[string]string{"error": "subscriber is too slow"})
+				flusher.Flush()
+				log.Printf(requestInfo(t, http.StatusOK, r, "stream:dropped"))
+				return
+			}
+
+			if n := sub.Lost(); n > 0 {
+				writeEvent(w, 0, "lost", map[string]uint64{"count": n})
+			}
+
+			id, name, data := encode(item)
+			err = writeEvent(w, id, name, data)
+
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+
+		case <-r.Context().Done():
+			log.Printf(requestInfo(t, http.StatusOK, r, "stream:closed"))
+			return
+		}
+
+		flusher.Flush()
+	}
+
+	log.Printf(requestInfo(t, http.StatusOK, r, "stream:error:%s", err.Error()))
+}
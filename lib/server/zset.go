@@ -0,0 +1,403 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// Sorted set values are sets of members ordered by score. Every added
+// or removed member is replicated separately. Sorted sets are modified in
+// place so even reads go through the cache lock.
+const zsetPath = "/_zset/"
+
+var ErrScoreInvalid = errors.New("Score is not a finite number")
+
+type zsetItem struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+	Rank   *int64  `json:"rank,omitempty"`
+}
+
+func pathToZset(path string) string {
+	return strings.TrimPrefix(path, zsetPath)
+}
+
+// AddScores sets scores of the members of the sorted set and returns
+// the number of new members. Absent sorted set is created with expiration
+// time expiresInMs or the default one if expiresInMs is 0. Otherwise 0
+// keeps the expiration time of the sorted set.
+func (s *Server) AddScores(key string, items []sdk.SortedSetItem, expiresInMs int64) (int64, error) {
+
+	for _, x := range items {
+		if math.IsNaN(x.Score) || math.IsInf(x.Score, 0) {
+			return 0, ErrScoreInvalid
+		}
+	}
+
+	var n int64
+	err := s.updateZset(key, expiresInMs, func(z *sdk.SortedSet) ([]*sdk.ReplItem, error) {
+
+		// check the size before modifying the sorted set in place
+		size := z.Size()
+		for _, x := range items {
+			if _, ok := z.Score(x.Member); !ok {
+				size += int64(len(x.Member)) + 8
+			}
+		}
+
+		if size > s.cfg.MaxValueBytes {
+			return nil, ErrValueTooLarge
+		}
+
+		var changes []*sdk.ReplItem
+		for _, x := range items {
+			if score, ok := z.Score(x.Member); ok && score == x.Score {
+				continue
+			}
+
+			if z.Add(x.Member, x.Score) {
+				n++
+			}
+
+			changes = append(changes, &sdk.ReplItem{
+				Action: sdk.ReplActionZsetAdd,
+				Field:  x.Member,
+				Score:  x.Score,
+			})
+		}
+
+		return changes, nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// RemoveMembers removes the members of the sorted set and returns
+// the number of removed ones. The sorted set is deleted together with its
+// last member.
+func (s *Server) RemoveMembers(key string, members []string) (int64, error) {
+
+	var n int64
+	err := s.updateZset(key, 0, func(z *sdk.SortedSet) ([]*sdk.ReplItem, error) {
+
+		var changes []*sdk.ReplItem
+		for _, member := range members {
+			if z.Remove(member) {
+				n++
+				changes = append(changes, &sdk.ReplItem{
+					Action: sdk.ReplActionZsetRemove,
+					Field:  member,
+				})
+			}
+		}
+
+		return changes, nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Score returns the score of the member of the sorted set
+func (s *Server) Score(key, member string) (float64, bool, error) {
+
+	var score float64
+	var ok bool
+
+	err := s.viewZset(key, func(z *sdk.SortedSet) {
+		score, ok = z.Score(member)
+	})
+
+	return score, ok, err
+}
+
+// Rank returns the 0-based position of the member in the order of scores
+// or, if rev is set, in the reverse order together with the score
+func (s *Server) Rank(key, member string, rev bool) (int64, float64, bool, error) {
+
+	var rank int
+	var score float64
+	var ok bool
+
+	err := s.viewZset(key, func(z *sdk.SortedSet) {
+		if rank, ok = z.Rank(member); ok && rev {
+			rank = z.Len() - 1 - rank
+		}
+		score, _ = z.Score(member)
+	})
+
+	return int64(rank), score, ok, err
+}
+
+// Cardinality returns the number of members of the sorted set
+func (s *Server) Cardinality(key string) (int64, error) {
+
+	var n int
+	err := s.viewZset(key, func(z *sdk.SortedSet) {
+		n = z.Len()
+	})
+
+	return int64(n), err
+}
+
+// RangeByRank returns the members between positions start and stop
+// inclusive in the order of scores or, if rev is set, in the reverse order.
+// Negative positions are counted from the end, -1 is the last member.
+func (s *Server) RangeByRank(key string, start, stop int64, rev bool) ([]sdk.SortedSetItem, error) {
+
+	var items []sdk.SortedSetItem
+
+	err := s.viewZset(key, func(z *sdk.SortedSet) {
+
+		n := int64(z.Len())
+		begin, end := listRange(n, start, stop)
+		if begin == end {
+			return
+		}
+
+		if !rev {
+			items = z.RangeByRank(int(begin), int(end-1))
+			return
+		}
+
+		items = z.RangeByRank(int(n-end), int(n-1-begin))
+		reverseItems(items)
+	})
+
+	return items, err
+}
+
+// RangeByScore returns the members with scores between min and max
+// inclusive in the order of scores or, if rev is set, in the reverse order
+func (s *Server) RangeByScore(key string, min, max float64, rev bool) ([]sdk.SortedSetItem, error) {
+
+	var items []sdk.SortedSetItem
+
+	err := s.viewZset(key, func(z *sdk.SortedSet) {
+		if items = z.RangeByScore(min, max); rev {
+			reverseItems(items)
+		}
+	})
+
+	return items, err
+}
+
+func reverseItems(items []sdk.SortedSetItem) {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+}
+
+// viewZset calls fn with the sorted set of the key under the cache lock.
+// fn isn't called if the key is absent.
+func (s *Server) viewZset(key string, fn func(z *sdk.SortedSet)) error {
+
+	if sdk.IsReserved(key) {
+		return nil
+	}
+
+	var err error
+
+	(*s.cache).Update(sdk.KeyInfo{Expires: sdk.NowMs(), Key: key},
+		func(rec *sdk.Record, ok bool) int8 {
+
+			if ok && !rec.IsZset() {
+				err = ErrWrongType
+			} else if ok {
+				fn(rec.Zset)
+			}
+
+			return sdk.UpdateKeep
+		})
+
+	return err
+}
+
+// updateZset calls fn to modify the sorted set of the key in place by
+// updateCollection
+func (s *Server) updateZset(key string, expiresInMs int64,
+	fn func(z *sdk.SortedSet) ([]*sdk.ReplItem, error)) error {
+
+	return s.updateCollection(key, expiresInMs, func(rec *sdk.Record, ok bool) ([]*sdk.ReplItem, error) {
+
+		if !ok {
+			rec.Zset = sdk.NewSortedSet()
+		} else if !rec.IsZset() {
+			return nil, ErrWrongType
+		}
+
+		return fn(rec.Zset)
+	})
+}
+
+func writeZsetError(t time.Time, w http.ResponseWriter, r *http.Request, err error) {
+
+	code := http.StatusBadRequest
+	switch err {
+	case ErrWrongType:
+		code = http.StatusConflict
+	case ErrValueTooLarge:
+		code = http.StatusRequestEntityTooLarge
+	}
+
+	w.WriteHeader(code)
+	w.Write([]byte(err.Error()))
+	log.Printf(requestInfo(t, code, r, "error:%s", err.Error()))
+}
+
+// parseScore parses the query parameter or returns def if it's absent
+func parseScore(query map[string][]string, name string, def float64) (float64, error) {
+
+	if query[name] == nil {
+		return def, nil
+	}
+
+	x, err := strconv.ParseFloat(query[name][0], 64)
+	if err != nil || math.IsNaN(x) {
+		return 0, ErrScoreInvalid
+	}
+
+	return x, nil
+}
+
+func writeJSON(t time.Time, w http.ResponseWriter, r *http.Request, data interface{}) {
+
+	body, _ := json.Marshal(data)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	log.Printf(requestInfo(t, http.StatusOK, r, "value_size:%d", len(body)))
+}
+
+// Lookup for the member by GET /_zset/somekey?member=somemember, for members
+// between positions by GET /_zset/somekey?start=0&stop=9 or for members
+// with scores between min and max by GET /_zset/somekey?min=1&max=10.
+// Parameter rev reverses the order.
+func (s *Server) getZsetHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	key := pathToZset(r.URL.Path)
+	query := r.URL.Query()
+	rev := query["rev"] != nil
+
+	if query["member"] != nil {
+		member := query.Get("member")
+
+		rank, score, ok, err := s.Rank(key, member, rev)
+		if err != nil {
+			writeZsetError(t, w, r, err)
+			return
+		}
+
+		if !ok {
+			http.NotFound(w, r)
+			log.Printf(requestInfo(t, http.StatusNotFound, r, ""))
+			return
+		}
+
+		writeJSON(t, w, r, zsetItem{Member: member, Score: score, Rank: &rank})
+		return
+	}
+
+	var items []sdk.SortedSetItem
+	var err error
+
+	if query["min"] != nil || query["max"] != nil {
+		var min, max float64
+		if min, err = parseScore(query, "min", math.Inf(-1)); err == nil {
+			if max, err = parseScore(query, "max", math.Inf(1)); err == nil {
+				items, err = s.RangeByScore(key, min, max, rev)
+			}
+		}
+	} else {
+		var start, stop int64
+		if start, stop, err = parseRange(r); err == nil {
+			items, err = s.RangeByRank(key, start, stop, rev)
+		}
+	}
+
+	if err != nil {
+		writeZsetError(t, w, r, err)
+		return
+	}
+
+	result := make([]zsetItem, len(items))
+	for i, x := range items {
+		result[i] = zsetItem{Member: x.Member, Score: x.Score}
+	}
+
+	writeJSON(t, w, r, result)
+}
+
+// Set the score of the member by POST /_zset/somekey?member=somemember&score=1.5
+// The body is 1 if the member is new and 0 otherwise.
+func (s *Server) addZsetHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+	member := query.Get("member")
+
+	if query["member"] == nil || query["score"] == nil {
+		writeZsetError(t, w, r, errors.New("Parameters member and score are required"))
+		return
+	}
+
+	score, err := parseScore(query, "score", 0)
+	if err != nil {
+		writeZsetError(t, w, r, err)
+		return
+	}
+
+	// the expiration time of existed sorted set is kept without the header
+	var expiresInMs int64
+
+	if hasHeaderContentExpires(r) {
+		if expiresInMs, err = s.parseHeaderContentExpires(r); err != nil {
+			writeZsetError(t, w, r, err)
+			return
+		}
+	}
+
+	n, err := s.AddScores(pathToZset(r.URL.Path), []sdk.SortedSetItem{{Member: member, Score: score}}, expiresInMs)
+	if err != nil {
+		writeZsetError(t, w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(strconv.FormatInt(n, 10)))
+	log.Printf(requestInfo(t, http.StatusOK, r, "member:%s score:%g", member, score))
+}
+
+// Remove the member by DELETE /_zset/somekey?member=somemember or the whole
+// sorted set by DELETE /_zset/somekey
+func (s *Server) deleteZsetHandler(t time.Time, w http.ResponseWriter, r *http.Request) {
+
+	key := pathToZset(r.URL.Path)
+	query := r.URL.Query()
+
+	if query["member"] == nil {
+		s.Delete(key)
+	} else if _, err := s.RemoveMembers(key, []string{query.Get("member")}); err != nil {
+		writeZsetError(t, w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Printf(requestInfo(t, http.StatusOK, r, ""))
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+func TestZsetScores(t *testing.T) {
+
+	s, repl := newTestServer()
+	replicated := len(repl.items)
+
+	added, err := s.AddScores("z", []sdk.SortedSetItem{
+		{Member: "a", Score: 3}, {Member: "b", Score: 1}, {Member: "c", Score: 2},
+	}, 0)
+	if err != nil || added != 3 {
+		t.Fatalf("AddScores() = %d, %v; wants 3, nil", added, err)
+	}
+
+	// the unchanged score is neither counted nor replicated
+	added, err = s.AddScores("z", []sdk.SortedSetItem{{Member: "a", Score: 3}, {Member: "b", Score: 4}}, 0)
+	if err != nil || added != 0 {
+		t.Errorf("AddScores() of existing members = %d, %v; wants 0, nil", added, err)
+	}
+
+	if rank, score, ok, err := s.Rank("z", "b", false); err != nil || !ok || rank != 2 || score != 4 {
+		t.Errorf("Rank(b) = %d, %v, %v, %v; wants 2, 4, true, nil", rank, score, ok, err)
+	}
+
+	if rank, _, ok, _ := s.Rank("z", "b", true); !ok || rank != 0 {
+		t.Errorf("Rank(b, rev) = %d, %v; wants 0, true", rank, ok)
+	}
+
+	items, err := s.RangeByRank("z", 0, -1, true)
+	if err != nil || len(items) != 3 || items[0].Member != "b" || items[2].Member != "c" {
+		t.Errorf("RangeByRank(rev) = %v, %v; wants b a c", items, err)
+	}
+
+	items, err = s.RangeByScore("z", 2, 3, false)
+	if err != nil || len(items) != 2 || items[0].Member != "c" || items[1].Member != "a" {
+		t.Errorf("RangeByScore() = %v, %v; wants c a", items, err)
+	}
+
+	if n, err := s.RemoveMembers("z", []string{"a", "b", "c", "x"}); err != nil || n != 3 {
+		t.Errorf("RemoveMembers() = %d, %v; wants 3, nil", n, err)
+	}
+
+	if s.Exists("z") {
+		t.Errorf("sorted set exists after removing the last member")
+	}
+
+	actions := []int8{sdk.ReplActionZsetAdd, sdk.ReplActionZsetAdd, sdk.ReplActionZsetAdd, sdk.ReplActionZsetAdd,
+		sdk.ReplActionZsetRemove, sdk.ReplActionZsetRemove, sdk.ReplActionZsetRemove,
+		sdk.ReplActionDelete}
+	got := repl.items[replicated:]
+	if len(got) != len(actions) {
+		t.Fatalf("replicated %d items; wants %d", len(got), len(actions))
+	}
+
+	for i, x := range actions {
+		if got[i].Action != x || got[i].Value.Zset != nil {
+			t.Errorf("item %d = %v; wants member-level action %d", i, got[i], x)
+		}
+	}
+
+	if got[3].Field != "b" || got[3].Score != 4 {
+		t.Errorf("item 3 = %s %v; wants b 4", got[3].Field, got[3].Score)
+	}
+
+	s.Set("plain", []byte("1"), 0, SetAlways)
+	if _, err := s.AddScores("plain", []sdk.SortedSetItem{{Member: "a"}}, 0); err != ErrWrongType {
+		t.Errorf("AddScores() to plain value = %v; wants %v", err, ErrWrongType)
+	}
+}
@@ -0,0 +1,88 @@
+package simplecache
+
+import (
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// The sampling is repeated while more than this part of the sample is expired
+const activeExpireRepeatRatio = 0.25
+
+// ActiveExpire deletes expired records every period independently of the
+// scheduler. It checks sampleSize random records at a time and repeats
+// while more than a quarter of them are expired but no longer than
+// a quarter of the period. The deleted records are canceled in sched
+// and every listener is called for each of them.
+// Should be run in a separete goroutine
+func (c *SimpleCache) ActiveExpire(sched sdk.Scheduler, period time.Duration, sampleSize int,
+	listeners ...func(key sdk.KeyInfo)) {
+
+	timer := time.NewTicker(period)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			c.activeExpireCycle(sched, period/4, sampleSize, listeners)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *SimpleCache) activeExpireCycle(sched sdk.Scheduler, budget time.Duration, sampleSize int,
+	listeners []func(key sdk.KeyInfo)) {
+
+	c.opsActiveCyclesTotal.Inc()
+	deadline := time.Now().Add(budget)
+
+	for {
+		expired, n := c.expireSample(sdk.NowMs(), sampleSize)
+
+		for _, keyinfo := range expired {
+			sched.Cancel(keyinfo)
+
+			for _, f := range listeners {
+				f(keyinfo)
+			}
+		}
+
+		if float64(len(expired)) <= activeExpireRepeatRatio*float64(n) || time.Now().After(deadline) {
+			return
+		}
+	}
+}
+
+// expireSample deletes records of the sample expired at the moment now.
+// It returns the deleted records and the size of the sample. The sample
+// is the records the iteration over the map starts from, the start of
+// the iteration is random.
+func (c *SimpleCache) expireSample(now int64, sampleSize int) ([]sdk.KeyInfo, int) {
+
+	var expired []sdk.KeyInfo
+	n := 0
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for k, rec := range c.data {
+		if n == sampleSize {
+			break
+		}
+		n++
+
+		// stale records are kept until the hard expiration time
+		if expires := rec.HardExpires(); expires <= now {
+			delete(c.data, k)
+			expired = append(expired, sdk.KeyInfo{Expires: expires, Key: k})
+		}
+	}
+
+	if len(expired) > 0 {
+		c.opsKeysTotal.Sub(float64(len(expired)))
+		c.opsActiveExpiredTotal.Add(float64(len(expired)))
+	}
+
+	return expired, n
+}
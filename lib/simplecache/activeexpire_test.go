@@ -0,0 +1,69 @@
+package simplecache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// testScheduler records canceled keys
+type testScheduler struct {
+	canceled []sdk.KeyInfo
+}
+
+func (s *testScheduler) Add(key sdk.KeyInfo)          {}
+func (s *testScheduler) Cancel(key sdk.KeyInfo)       { s.canceled = append(s.canceled, key) }
+func (s *testScheduler) GetChan() *chan []sdk.KeyInfo { return nil }
+func (s *testScheduler) Flush(namespace string)       {}
+
+func TestActiveExpire(t *testing.T) {
+
+	c := newSimpleCache(prometheus.NewRegistry())
+	now := sdk.NowMs()
+
+	for i := 0; i < 1000; i++ {
+		c.Insert(sdk.KeyInfo{Key: fmt.Sprintf("dead%d", i)}, *sdk.NewRecord(now-1000, []byte("x")))
+	}
+
+	for i := 0; i < 100; i++ {
+		c.Insert(sdk.KeyInfo{Key: fmt.Sprintf("live%d", i)}, *sdk.NewRecord(now+3600000, []byte("x")))
+	}
+
+	// the expired record kept to be served stale
+	stale := *sdk.NewRecord(now-1000, []byte("x"))
+	stale.StaleExpires = now + 3600000
+	c.Insert(sdk.KeyInfo{Key: "stale"}, stale)
+
+	sched := &testScheduler{}
+	var notified []sdk.KeyInfo
+	c.activeExpireCycle(sched, time.Minute, 20, []func(key sdk.KeyInfo){
+		func(key sdk.KeyInfo) { notified = append(notified, key) },
+	})
+
+	// the cycle is repeated while more than a quarter of the sample is expired
+	if len(c.data) > 1101-100 {
+		t.Errorf("%d records are left; wants not more than %d", len(c.data), 1101-100)
+	}
+
+	if len(notified) != 1101-len(c.data) {
+		t.Errorf("%d records are notified; wants %d", len(notified), 1101-len(c.data))
+	}
+
+	for _, key := range notified {
+		if key.Expires != now-1000 || key.Key[:4] != "dead" {
+			t.Errorf("%v is notified; wants expired records only", key)
+		}
+	}
+
+	if len(sched.canceled) != len(notified) {
+		t.Errorf("%d records are canceled in the scheduler; wants %d", len(sched.canceled), len(notified))
+	}
+
+	if _, ok := c.data["stale"]; !ok {
+		t.Errorf("the stale record is deleted")
+	}
+}
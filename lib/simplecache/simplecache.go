@@ -2,6 +2,7 @@ package simplecache
 
 import (
 	"sync"
+	"time"
 
 	"github.com/iaroslavscript/cacheman/lib/sdk"
 
@@ -12,20 +13,47 @@ import (
 const metricsSubsystem = "cache"
 
 type SimpleCache struct {
-	data                map[string]sdk.Record
-	done                chan bool
-	m                   sync.RWMutex
-	opsApiRequestsTotal prometheus.Counter
-	opsKeysTotal        prometheus.Gauge
-	opsUsageBytes       prometheus.Gauge
+	data                  map[string]sdk.Record
+	done                  chan bool
+	m                     sync.RWMutex
+	opsActiveCyclesTotal  prometheus.Counter
+	opsActiveExpiredTotal prometheus.Counter
+	opsApiRequestsTotal   prometheus.Counter
+	opsExpiryLag          prometheus.Histogram
+	opsKeysTotal          prometheus.Gauge
+	opsUsageBytes         prometheus.Gauge
+	waiters               *waiters
 }
 
 func NewSimpleCache() *SimpleCache {
+	return newSimpleCache(prometheus.DefaultRegisterer)
+}
+
+// newSimpleCache creates the cache with the metrics registered in reg
+func newSimpleCache(reg prometheus.Registerer) *SimpleCache {
+	factory := promauto.With(reg)
 	c := SimpleCache{
-		data: make(map[string]sdk.Record),
-		done: make(chan bool),
+		data:    make(map[string]sdk.Record),
+		done:    make(chan bool),
+		waiters: newWaiters(),
+
+		opsActiveCyclesTotal: factory.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: sdk.MetricsNamespace,
+				Subsystem: metricsSubsystem,
+				Name:      "active_expire_cycles_total",
+				Help:      "The total number of cycles of active expiration",
+			}),
 
-		opsApiRequestsTotal: promauto.NewCounter(
+		opsActiveExpiredTotal: factory.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: sdk.MetricsNamespace,
+				Subsystem: metricsSubsystem,
+				Name:      "active_expired_total",
+				Help:      "The total number of expired records deleted by active expiration",
+			}),
+
+		opsApiRequestsTotal: factory.NewCounter(
 			prometheus.CounterOpts{
 				Namespace: sdk.MetricsNamespace,
 				Subsystem: metricsSubsystem,
@@ -33,7 +61,16 @@ func NewSimpleCache() *SimpleCache {
 				Help:      "The total number of requests to cache API",
 			}),
 
-		opsKeysTotal: promauto.NewGauge(
+		opsExpiryLag: factory.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: sdk.MetricsNamespace,
+				Subsystem: metricsSubsystem,
+				Name:      "expiry_lag_seconds",
+				Help:      "The time between the expiration of records and their deletion by the scheduler",
+				Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+			}),
+
+		opsKeysTotal: factory.NewGauge(
 			prometheus.GaugeOpts{
 				Namespace: sdk.MetricsNamespace,
 				Subsystem: metricsSubsystem,
@@ -41,7 +78,7 @@ func NewSimpleCache() *SimpleCache {
 				Help:      "The total number of keys stored in cache",
 			}),
 
-		opsUsageBytes: promauto.NewGauge(
+		opsUsageBytes: factory.NewGauge(
 			prometheus.GaugeOpts{
 				Namespace: sdk.MetricsNamespace,
 				Subsystem: metricsSubsystem,
@@ -50,6 +87,8 @@ func NewSimpleCache() *SimpleCache {
 			}),
 	}
 
+	c.opsActiveCyclesTotal.Add(0.0)
+	c.opsActiveExpiredTotal.Add(0.0)
 	c.opsApiRequestsTotal.Add(0.0)
 	c.opsKeysTotal.Add(0.0)
 	c.opsUsageBytes.Add(0.0)
@@ -71,6 +110,8 @@ func (c *SimpleCache) Insert(key sdk.KeyInfo, rec sdk.Record) {
 	}
 	c.data[key.Key] = rec
 	c.m.Unlock()
+
+	c.waiters.notify(key.Key)
 }
 
 // Search for record equal to KeyInfo.Key which is not expired at the moment
@@ -86,7 +127,7 @@ func (c *SimpleCache) Lookup(key sdk.KeyInfo) (sdk.Record, bool) {
 
 	if rec.Expires <= key.Expires {
 		// the record will be expired at the requested moment key.Expires.
-		// If key.Expires == time.Now().Unix() it means that the record
+		// If key.Expires == sdk.NowMs() it means that the record
 		// has already expired but the scheduler hasn't fired yet.
 		ok = false
 	}
@@ -96,35 +137,121 @@ func (c *SimpleCache) Lookup(key sdk.KeyInfo) (sdk.Record, bool) {
 
 // Delete record specified by key.Key
 // If the record has been overwriten it will not be deleted
-func (c *SimpleCache) Delete(key sdk.KeyInfo) {
+func (c *SimpleCache) Delete(key sdk.KeyInfo) bool {
 
 	c.opsApiRequestsTotal.Inc()
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	if rec, ok := c.data[key.Key]; ok && rec.Expires <= key.Expires {
+	if rec, ok := c.data[key.Key]; ok && rec.HardExpires() <= key.Expires {
 		// we need this check because record could have been overwriten
 		// by new one and we don't need to delete it in that case.
+		// Stale records are kept until the hard expiration time.
 
 		c.opsKeysTotal.Dec()
 		c.opsUsageBytes.Set(0.0) // Curently we are not counting bytes
 		delete(c.data, key.Key)
+		c.waiters.notify(key.Key)
+		return true
 	}
+
+	return false
 }
 
-// Reading records from chan and call Expired func.
+// Update atomically reads, modifies and writes back the record.
+// The record expired at the moment of key.Expires is treated as absent.
+func (c *SimpleCache) Update(key sdk.KeyInfo, fn sdk.UpdateFunc) {
+
+	c.opsApiRequestsTotal.Inc()
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	rec, exists := c.data[key.Key]
+	ok := exists && rec.Expires > key.Expires
+	if !ok {
+		rec = sdk.Record{}
+	}
+
+	switch fn(&rec, ok) {
+	case sdk.UpdateStore:
+		if !exists {
+			c.opsKeysTotal.Inc()
+		}
+		c.data[key.Key] = rec
+		c.waiters.notify(key.Key)
+
+	case sdk.UpdateDelete:
+		if exists {
+			c.opsKeysTotal.Dec()
+			delete(c.data, key.Key)
+			c.waiters.notify(key.Key)
+		}
+	}
+}
+
+// Wait returns a channel which is closed when the key is stored or deleted
+// next time.
+// The cancel func should be called if the caller stops waiting before that.
+func (c *SimpleCache) Wait(key string) (<-chan struct{}, func()) {
+	return c.waiters.add(key)
+}
+
+// Flush removes all records of namespace or all records at all
+// if namespace is empty.
+func (c *SimpleCache) Flush(namespace string) {
+
+	c.opsApiRequestsTotal.Inc()
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if namespace == "" {
+		c.data = make(map[string]sdk.Record)
+	} else {
+		for k := range c.data {
+			if sdk.InNamespace(k, namespace) {
+				delete(c.data, k)
+			}
+		}
+	}
+
+	c.opsKeysTotal.Set(float64(len(c.data)))
+	c.opsUsageBytes.Set(0.0) // Curently we are not counting bytes
+}
+
+// Reading batches of records from chan and call Expired func.
+// Every listener is called for each deleted record.
 // Should be run in a separete goroutine
-func (c *SimpleCache) WatchSheduler(sched sdk.Scheduler) {
+func (c *SimpleCache) WatchSheduler(sched sdk.Scheduler, listeners ...func(key sdk.KeyInfo)) {
 	for {
 		select {
-		case keyinfo := <-*sched.GetChan():
-			c.Delete(keyinfo)
+		case batch := <-*sched.GetChan():
+			for _, keyinfo := range batch {
+				c.expire(sched, keyinfo, listeners)
+			}
 		case <-c.done:
 			return
 		}
 	}
 }
 
+func (c *SimpleCache) expire(sched sdk.Scheduler, keyinfo sdk.KeyInfo, listeners []func(key sdk.KeyInfo)) {
+
+	if c.Delete(keyinfo) {
+		lag := time.Since(time.Unix(0, keyinfo.Expires*int64(time.Millisecond)))
+		c.opsExpiryLag.Observe(lag.Seconds())
+
+		for _, f := range listeners {
+			f(keyinfo)
+		}
+	} else if rec, ok := c.Lookup(sdk.KeyInfo{Expires: 0, Key: keyinfo.Key}); ok &&
+		rec.HardExpires() > keyinfo.Expires {
+		// the key is scheduled once, concurrent writes could have
+		// moved it to the time of the record overwritten since then
+		sched.Add(sdk.KeyInfo{Expires: rec.HardExpires(), Key: keyinfo.Key})
+	}
+}
+
+// Close stops WatchSheduler and ActiveExpire
 func (c *SimpleCache) Close() {
-	c.done <- true
+	close(c.done)
 }
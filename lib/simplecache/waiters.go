@@ -0,0 +1,57 @@
+package simplecache
+
+import "sync"
+
+// The registry of clients waiting for keys to be stored or deleted
+type waiters struct {
+	m    sync.Mutex
+	keys map[string]map[chan struct{}]bool
+}
+
+func newWaiters() *waiters {
+	return &waiters{
+		keys: make(map[string]map[chan struct{}]bool),
+	}
+}
+
+func (w *waiters) add(key string) (<-chan struct{}, func()) {
+
+	ch := make(chan struct{})
+
+	w.m.Lock()
+	chans, ok := w.keys[key]
+	if !ok {
+		chans = make(map[chan struct{}]bool)
+		w.keys[key] = chans
+	}
+	chans[ch] = true
+	w.m.Unlock()
+
+	cancel := func() {
+		w.m.Lock()
+		defer w.m.Unlock()
+
+		if chans, ok := w.keys[key]; ok && chans[ch] {
+			delete(chans, ch)
+			if len(chans) == 0 {
+				delete(w.keys, key)
+			}
+		}
+	}
+
+	return ch, cancel
+}
+
+// notify wakes up and removes every waiter of the key
+func (w *waiters) notify(key string) {
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if chans, ok := w.keys[key]; ok {
+		for ch := range chans {
+			close(ch)
+		}
+		delete(w.keys, key)
+	}
+}
@@ -0,0 +1,65 @@
+package simplescheduler
+
+import (
+	"log"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// queueBatches returns the capacity of the channel of batches holding
+// about sheduler_expired_queque_size records
+func queueBatches(cfg *config.Config) int64 {
+
+	if n := cfg.ShedulerExpiredQuequeSize / cfg.ShedulerBatchSize; n > 1 {
+		return n
+	}
+
+	return 1
+}
+
+// deliver sends the due records to c in batches of up to size records
+// without blocking. Not more than limit records are sent if limit is
+// greater than 0, so many records expiring at once are spread across
+// ticks. It returns the records not sent and whether c had no room for
+// them, they are sent on the next tick so the scheduler never stalls
+// on a slow consumer.
+func deliver(c chan []sdk.KeyInfo, due []sdk.KeyInfo, size, limit int) ([]sdk.KeyInfo, bool) {
+
+	sent := 0
+	for len(due) > 0 {
+		n := len(due)
+		if n > size {
+			n = size
+		}
+
+		if limit > 0 {
+			if sent == limit {
+				return due, false
+			}
+
+			if n > limit-sent {
+				n = limit - sent
+			}
+		}
+
+		select {
+		case c <- due[:n:n]:
+			due = due[n:]
+			sent += n
+		default:
+			return due, true
+		}
+	}
+
+	return nil, false
+}
+
+// logPending reports the records delayed by the full queue once
+// when the queue becomes full
+func logPending(c chan []sdk.KeyInfo, wasFull, full bool, n int) {
+
+	if !wasFull && full {
+		log.Printf("scheduler queue size(%d) full. %d expired records are delayed.", cap(c), n)
+	}
+}
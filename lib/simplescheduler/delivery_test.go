@@ -0,0 +1,92 @@
+package simplescheduler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDeliver(t *testing.T) {
+
+	c := make(chan []sdk.KeyInfo, 2)
+
+	due := make([]sdk.KeyInfo, 25)
+	for i := range due {
+		due[i] = sdk.KeyInfo{Expires: int64(i), Key: fmt.Sprintf("k%d", i)}
+	}
+
+	// the channel has room for 2 batches of 10 records
+	pending, full := deliver(c, due, 10, 0)
+	if !full || len(pending) != 5 || pending[0].Key != "k20" {
+		t.Fatalf("%d records are pending; wants k20 to k24", len(pending))
+	}
+
+	if batch := <-c; len(batch) != 10 || batch[0].Key != "k0" {
+		t.Errorf("the first batch has %d records from %v; wants 10 from k0", len(batch), batch[0])
+	}
+
+	// new records are appended to the pending ones
+	pending = append(pending, sdk.KeyInfo{Expires: 25, Key: "k25"})
+	if pending, full = deliver(c, pending, 10, 0); full || pending != nil {
+		t.Errorf("%d records are pending; wants 0", len(pending))
+	}
+
+	<-c
+	if batch := <-c; len(batch) != 6 || batch[0].Key != "k20" || batch[5].Key != "k25" {
+		t.Errorf("the last batch is %v; wants k20 to k25", batch)
+	}
+}
+
+func TestDeliverLimit(t *testing.T) {
+
+	c := make(chan []sdk.KeyInfo, 10)
+
+	due := make([]sdk.KeyInfo, 25)
+	for i := range due {
+		due[i] = sdk.KeyInfo{Expires: int64(i), Key: fmt.Sprintf("k%d", i)}
+	}
+
+	// 15 records are sent per tick in batches of 10 and 5
+	pending, full := deliver(c, due, 10, 15)
+	if full || len(pending) != 10 || pending[0].Key != "k15" {
+		t.Fatalf("%d records are pending, full %v; wants k15 to k24 not full", len(pending), full)
+	}
+
+	if len(c) != 2 {
+		t.Errorf("%d batches are sent; wants 2", len(c))
+	}
+
+	<-c
+	if batch := <-c; len(batch) != 5 || batch[4].Key != "k14" {
+		t.Errorf("the second batch is %v; wants k10 to k14", batch)
+	}
+
+	if pending, full = deliver(c, pending, 10, 15); full || pending != nil {
+		t.Errorf("%d records are pending, full %v; wants 0", len(pending), full)
+	}
+}
+
+func TestTickNotBlocking(t *testing.T) {
+
+	cfg := *config.GetConfig()
+	cfg.ShedulerExpiredQuequeSize = cfg.ShedulerBatchSize
+	s := newWheelExpirer(&cfg, prometheus.NewRegistry())
+
+	// nobody reads the channel having room for one batch
+	n := int(cfg.ShedulerBatchSize)
+	for i := 0; i < 3*n; i++ {
+		s.Add(sdk.KeyInfo{Expires: 1, Key: fmt.Sprintf("k%d", i)})
+	}
+
+	s.tick(time.Now().Add(time.Second))
+	s.Add(sdk.KeyInfo{Expires: 1, Key: "late"})
+	s.tick(time.Now().Add(2 * time.Second))
+
+	if len(s.C) != 1 || len(s.pending) != 2*n+1 {
+		t.Errorf("%d batches are sent, %d records are pending; wants 1 and %d", len(s.C), len(s.pending), 2*n+1)
+	}
+}
@@ -4,6 +4,8 @@ package simplescheduler
 type schedHeapItem struct {
 	value    string
 	priority int64
+	expires  int64 // the expiration time of the record
+	index    int   // the position of the item in the heap
 }
 
 type SchedMinHeap []*schedHeapItem
@@ -21,10 +23,14 @@ func (h SchedMinHeap) Less(i, j int) bool {
 func (h SchedMinHeap) Swap(i, j int) {
 
 	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
 }
 
 func (h *SchedMinHeap) Push(x interface{}) {
-	*h = append(*h, x.(*schedHeapItem))
+	item := x.(*schedHeapItem)
+	item.index = len(*h)
+	*h = append(*h, item)
 }
 
 func (h *SchedMinHeap) Pop() interface{} {
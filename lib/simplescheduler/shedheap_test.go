@@ -3,23 +3,27 @@ package simplescheduler
 import (
 	"container/heap"
 	"testing"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func generateData() []*schedHeapItem {
 
 	result := []*schedHeapItem{
 		&schedHeapItem{
-			values:   []string{"A"},
+			value:    "A",
 			priority: 30,
 		},
 
 		&schedHeapItem{
-			values:   []string{"B"},
+			value:    "B",
 			priority: 20,
 		},
 
 		&schedHeapItem{
-			values:   []string{"C"},
+			value:    "C",
 			priority: 10,
 		},
 	}
@@ -70,3 +74,43 @@ func TestPush(t *testing.T) {
 	}
 
 }
+
+func TestSimpleExpirerAdd(t *testing.T) {
+
+	cfg := *config.GetConfig()
+	cfg.ShedulerDelExpiredEverySec = 10
+	s := newSimpleExpirer(&cfg, prometheus.NewRegistry())
+
+	for i := int64(0); i < 100; i++ {
+		s.Add(sdk.KeyInfo{Expires: 1000000 - i*10000, Key: "a"})
+	}
+	s.Add(sdk.KeyInfo{Expires: 500000, Key: "b"})
+	s.Add(sdk.KeyInfo{Expires: 2000000, Key: "c"})
+
+	if s.timetable.Len() != 3 || len(s.items) != 3 {
+		t.Fatalf("%d records are scheduled; wants 3", s.timetable.Len())
+	}
+
+	// the record is moved to the earliest time of the heap
+	if s.timetable[0].value != "a" || s.timetable[0].priority != 20000 {
+		t.Errorf("the first record is %s at %d; wants a at 20000", s.timetable[0].value, s.timetable[0].priority)
+	}
+
+	s.Cancel(sdk.KeyInfo{Expires: 1000000, Key: "c"})
+	if _, ok := s.items["c"]; !ok {
+		t.Errorf("c expiring later is cancelled")
+	}
+
+	s.Cancel(sdk.KeyInfo{Expires: 10000, Key: "a"})
+	s.Cancel(sdk.KeyInfo{Expires: 2000000, Key: "c"})
+
+	if s.timetable.Len() != 1 || s.timetable[0].value != "b" {
+		t.Errorf("%d records are scheduled; wants b only", s.timetable.Len())
+	}
+
+	for i, item := range s.timetable {
+		if item.index != i {
+			t.Errorf("index of %s is %d; wants %d", item.value, item.index, i)
+		}
+	}
+}
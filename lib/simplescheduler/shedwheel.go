@@ -0,0 +1,208 @@
+package simplescheduler
+
+import (
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// The first level of the timing wheel has a slot for every tick, a slot
+// of every next level spans all slots of the previous level.
+const (
+	wheelRootBits  = 8
+	wheelLevelBits = 6
+	wheelLevels    = 5
+
+	wheelRootMask  = 1<<wheelRootBits - 1
+	wheelLevelMask = 1<<wheelLevelBits - 1
+
+	// items due later than this number of ticks are parked in the last level
+	wheelMaxDelta = 1<<(wheelRootBits+wheelLevelBits*(wheelLevels-1)) - 1
+)
+
+// An item of the timing wheel
+type wheelItem struct {
+	value    string
+	expires  int64 // the expiration time of the key
+	deadline int64 // the tick the item is due at
+	slot     *wheelSlot
+	prev     *wheelItem
+	next     *wheelItem
+}
+
+// wheelSlot is the doubly linked list of items
+type wheelSlot struct {
+	head *wheelItem
+}
+
+func (s *wheelSlot) push(x *wheelItem) {
+
+	x.slot, x.prev, x.next = s, nil, s.head
+	if s.head != nil {
+		s.head.prev = x
+	}
+	s.head = x
+}
+
+func (s *wheelSlot) remove(x *wheelItem) {
+
+	if x.prev != nil {
+		x.prev.next = x.next
+	} else {
+		s.head = x.next
+	}
+
+	if x.next != nil {
+		x.next.prev = x.prev
+	}
+
+	x.slot, x.prev, x.next = nil, nil, nil
+}
+
+// take empties the slot and returns its items linked by next
+func (s *wheelSlot) take() *wheelItem {
+
+	x := s.head
+	s.head = nil
+
+	return x
+}
+
+// timingWheel is the hierarchical timing wheel of keys due at ticks.
+// A key is added and removed in O(1). The key is put to the level by the
+// distance of its tick from the current one and moves to lower levels while
+// the wheel turns until it's due. Every key is kept once.
+type timingWheel struct {
+	now    int64 // the current tick
+	levels [wheelLevels][]wheelSlot
+	items  map[string]*wheelItem
+}
+
+func newTimingWheel(now int64) *timingWheel {
+
+	w := &timingWheel{
+		now:   now,
+		items: make(map[string]*wheelItem),
+	}
+
+	for level := range w.levels {
+		n := 1 << wheelLevelBits
+		if level == 0 {
+			n = 1 << wheelRootBits
+		}
+
+		w.levels[level] = make([]wheelSlot, n)
+	}
+
+	return w
+}
+
+func (w *timingWheel) Len() int {
+	return len(w.items)
+}
+
+// add schedules the key to the tick deadline, the key scheduled already
+// is moved to it. The key due already is due at the next tick.
+func (w *timingWheel) add(key string, expires, deadline int64) {
+
+	if x, ok := w.items[key]; ok {
+		x.slot.remove(x)
+	}
+
+	x := &wheelItem{value: key, expires: expires, deadline: deadline}
+	w.items[key] = x
+
+	if deadline <= w.now {
+		deadline = w.now + 1
+	}
+	w.place(x, deadline)
+}
+
+// remove cancels the key expiring not later than expires and reports
+// whether it was scheduled
+func (w *timingWheel) remove(key string, expires int64) bool {
+
+	x, ok := w.items[key]
+	if !ok || x.expires > expires {
+		return false
+	}
+
+	x.slot.remove(x)
+	delete(w.items, key)
+
+	return true
+}
+
+// flush cancels all keys of namespace or all keys at all if namespace is empty
+func (w *timingWheel) flush(namespace string) {
+
+	for key, x := range w.items {
+		if namespace == "" || sdk.InNamespace(key, namespace) {
+			x.slot.remove(x)
+			delete(w.items, key)
+		}
+	}
+}
+
+// advance turns the wheel to the next tick and calls fn for every due key
+func (w *timingWheel) advance(fn func(x *wheelItem)) {
+
+	w.now++
+
+	// slots of upper levels come down when all lower levels wrap around
+	shift := uint(wheelRootBits)
+	for level := 1; level < wheelLevels; level++ {
+		if w.now&(1<<shift-1) != 0 {
+			break
+		}
+
+		w.cascade(&w.levels[level][(w.now>>shift)&wheelLevelMask])
+		shift += wheelLevelBits
+	}
+
+	for x := w.levels[0][w.now&wheelRootMask].take(); x != nil; {
+		next := x.next
+		x.slot, x.prev, x.next = nil, nil, nil
+		delete(w.items, x.value)
+		fn(x)
+		x = next
+	}
+}
+
+// cascade puts keys of the slot of the upper level to lower levels
+func (w *timingWheel) cascade(slot *wheelSlot) {
+
+	for x := slot.take(); x != nil; {
+		next := x.next
+
+		deadline := x.deadline
+		if deadline < w.now {
+			deadline = w.now
+		}
+		w.place(x, deadline)
+
+		x = next
+	}
+}
+
+// place puts the item to the slot of tick at, at must not be before now
+func (w *timingWheel) place(x *wheelItem, at int64) {
+
+	delta := at - w.now
+	if delta > wheelMaxDelta {
+		at = w.now + wheelMaxDelta
+		delta = wheelMaxDelta
+	}
+
+	if delta <= wheelRootMask {
+		w.levels[0][at&wheelRootMask].push(x)
+		return
+	}
+
+	level := 1
+	shift := uint(wheelRootBits)
+	for delta >= 1<<(shift+wheelLevelBits) {
+		level++
+		shift += wheelLevelBits
+	}
+
+	w.levels[level][(at>>shift)&wheelLevelMask].push(x)
+}
@@ -0,0 +1,214 @@
+package simplescheduler
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// turn advances the wheel n ticks and returns the due keys with their ticks
+func turn(w *timingWheel, n int64) map[string]int64 {
+
+	due := make(map[string]int64)
+	for i := int64(0); i < n; i++ {
+		w.advance(func(x *wheelItem) {
+			due[x.value] = w.now
+		})
+	}
+
+	return due
+}
+
+func TestWheelAdvance(t *testing.T) {
+
+	const start = 1000003
+	w := newTimingWheel(start)
+
+	// ticks on every level and the boundaries between them
+	deltas := []int64{1, 2, 255, 256, 257, 1 << 14, 1<<14 + 1, 300000, 1 << 20, 1<<20 + 7}
+	for _, d := range deltas {
+		w.add(fmt.Sprintf("k%d", d), 0, start+d)
+	}
+
+	w.add("past", 0, start-10)
+
+	due := turn(w, 1<<20+7)
+
+	for _, d := range deltas {
+		key := fmt.Sprintf("k%d", d)
+		if due[key] != start+d {
+			t.Errorf("%s is due at %d; wants %d", key, due[key]-start, d)
+		}
+	}
+
+	if due["past"] != start+1 {
+		t.Errorf("past is due at %d; wants 1", due["past"]-start)
+	}
+
+	if w.Len() != 0 {
+		t.Errorf("w.Len() = %d; wants 0", w.Len())
+	}
+}
+
+func TestWheelRemove(t *testing.T) {
+
+	w := newTimingWheel(0)
+
+	w.add("a", 0, 10)
+	w.add("b", 1000, 1000)
+	w.add("ns/c", 0, 20)
+	w.add("ns/d", 0, 100000)
+
+	if w.remove("b", 999) {
+		t.Errorf("remove(b, 999) = true; wants false")
+	}
+
+	if !w.remove("b", 1000) {
+		t.Errorf("remove(b, 1000) = false; wants true")
+	}
+
+	if w.remove("b", 1000) {
+		t.Errorf("remove(b, 1000) twice = true; wants false")
+	}
+
+	w.flush("ns")
+
+	if w.Len() != 1 {
+		t.Errorf("w.Len() = %d; wants 1", w.Len())
+	}
+
+	due := turn(w, 200000)
+	if len(due) != 1 || due["a"] != 10 {
+		t.Errorf("due = %v; wants map[a:10]", due)
+	}
+}
+
+func TestWheelAddAgain(t *testing.T) {
+
+	w := newTimingWheel(0)
+
+	w.add("a", 1, 500)
+	w.add("a", 2, 30)
+	w.add("b", 1, 40)
+	w.add("b", 2, 5000)
+
+	if w.Len() != 2 {
+		t.Errorf("w.Len() = %d; wants 2", w.Len())
+	}
+
+	due := turn(w, 10000)
+	if len(due) != 2 || due["a"] != 30 || due["b"] != 5000 {
+		t.Errorf("due = %v; wants map[a:30 b:5000]", due)
+	}
+}
+
+func TestWheelExpirer(t *testing.T) {
+
+	cfg := config.GetConfig()
+	s := newWheelExpirer(cfg, prometheus.NewRegistry())
+	go s.Start()
+	defer s.Close()
+
+	now := sdk.NowMs()
+	s.Add(sdk.KeyInfo{Expires: now + 3600000, Key: "later"})
+	s.Add(sdk.KeyInfo{Expires: now, Key: "now"})
+
+	select {
+	case batch := <-s.C:
+		if len(batch) != 1 || batch[0].Key != "now" || batch[0].Expires != now {
+			t.Errorf("expired %v; wants now", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("key is not expired in 1s")
+	}
+
+	s.Cancel(sdk.KeyInfo{Expires: now + 3600000, Key: "later"})
+	if s.wheel.Len() != 0 {
+		t.Errorf("wheel.Len() = %d; wants 0", s.wheel.Len())
+	}
+}
+
+// ticks of records expiring in up to an hour with ticks of 10ms
+func benchmarkTicks(n int) []int64 {
+
+	ticks := make([]int64, n)
+	for i := range ticks {
+		ticks[i] = 1 + rand.Int63n(360000)
+	}
+
+	return ticks
+}
+
+func BenchmarkHeapAdd(b *testing.B) {
+
+	ticks := benchmarkTicks(b.N)
+	h := make(SchedMinHeap, 0)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		heap.Push(&h, &schedHeapItem{value: "key", priority: ticks[i]})
+	}
+}
+
+func BenchmarkWheelAdd(b *testing.B) {
+
+	ticks := benchmarkTicks(b.N)
+	keys := make([]string, b.N)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	w := newTimingWheel(0)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		w.add(keys[i], 0, ticks[i])
+	}
+}
+
+// benchmarks of adding the record and expiring it an hour later
+// while a million of records is scheduled
+
+const benchmarkScheduled = 1000000
+
+func BenchmarkHeapAddExpire(b *testing.B) {
+
+	h := make(SchedMinHeap, 0, benchmarkScheduled)
+	for i, x := range benchmarkTicks(benchmarkScheduled) {
+		h = append(h, &schedHeapItem{value: fmt.Sprintf("key%d", i), priority: x})
+	}
+	heap.Init(&h)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		now := int64(i)
+		heap.Push(&h, &schedHeapItem{value: "key", priority: now + 360000})
+		for h.Len() > 0 && h[0].priority <= now {
+			heap.Pop(&h)
+		}
+	}
+}
+
+func BenchmarkWheelAddExpire(b *testing.B) {
+
+	w := newTimingWheel(0)
+	for i, x := range benchmarkTicks(benchmarkScheduled) {
+		w.add(fmt.Sprintf("key%d", i), 0, x)
+	}
+	keys := make([]string, b.N)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("new%d", i)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		w.add(keys[i], 0, w.now+360000)
+		w.advance(func(x *wheelItem) {})
+	}
+}
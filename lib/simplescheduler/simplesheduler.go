@@ -14,41 +14,63 @@ import (
 
 const metricsSubsystem = "sched"
 
+// SimpleExpirer keeps scheduled records in the min-heap checked every
+// sheduler_del_expired_every_sec. Every key is scheduled once.
+// Expired records are sent in batches of sheduler_batch_size.
 type SimpleExpirer struct {
-	C                   chan sdk.KeyInfo
+	C                   chan []sdk.KeyInfo
 	cfg                 *config.Config
 	done                chan bool
+	items               map[string]*schedHeapItem
 	m                   sync.Mutex
 	opsApiRequestsTotal prometheus.Counter
+	opsPending          prometheus.Gauge
 	opsRecsTotal        prometheus.Gauge
 	opsTriggeredTotal   prometheus.Counter
+	full                bool          // the queue had no room on the last tick
+	pending             []sdk.KeyInfo // expired records not sent yet
 	timer               *time.Ticker
 	timetable           SchedMinHeap
 }
 
 func NewSimpleExpirer(cfg *config.Config) *SimpleExpirer {
 
+	return newSimpleExpirer(cfg, prometheus.DefaultRegisterer)
+}
+
+// newSimpleExpirer creates the expirer with the metrics registered in reg
+func newSimpleExpirer(cfg *config.Config, reg prometheus.Registerer) *SimpleExpirer {
+
+	factory := promauto.With(reg)
 	d := time.Duration(cfg.ShedulerDelExpiredEverySec) * time.Second
 	x := &SimpleExpirer{
-		C:    make(chan sdk.KeyInfo, cfg.ShedulerExpiredQuequeSize),
-		cfg:  cfg,
-		done: make(chan bool),
+		C:     make(chan []sdk.KeyInfo, queueBatches(cfg)),
+		cfg:   cfg,
+		done:  make(chan bool),
+		items: make(map[string]*schedHeapItem),
 
-		opsApiRequestsTotal: promauto.NewCounter(prometheus.CounterOpts{
+		opsApiRequestsTotal: factory.NewCounter(prometheus.CounterOpts{
 			Namespace: sdk.MetricsNamespace,
 			Subsystem: metricsSubsystem,
 			Name:      "api_requests_total",
 			Help:      "The total number of requests to scheduler API",
 		}),
 
-		opsRecsTotal: promauto.NewGauge(prometheus.GaugeOpts{
+		opsPending: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "pending",
+			Help:      "The number of expired records waiting for room in the queue",
+		}),
+
+		opsRecsTotal: factory.NewGauge(prometheus.GaugeOpts{
 			Namespace: sdk.MetricsNamespace,
 			Subsystem: metricsSubsystem,
 			Name:      "records_total",
 			Help:      "The number of records are sheduled for expiring",
 		}),
 
-		opsTriggeredTotal: promauto.NewCounter(prometheus.CounterOpts{
+		opsTriggeredTotal: factory.NewCounter(prometheus.CounterOpts{
 			Namespace: sdk.MetricsNamespace,
 			Subsystem: metricsSubsystem,
 			Name:      "triggered_total",
@@ -61,27 +83,62 @@ func NewSimpleExpirer(cfg *config.Config) *SimpleExpirer {
 	heap.Init(&(x.timetable))
 
 	x.opsApiRequestsTotal.Add(0.0)
+	x.opsPending.Set(0.0)
 	x.opsRecsTotal.Set(0.0)
 	x.opsTriggeredTotal.Add(0.0)
 
 	return x
 }
 
+// Add schedules the record. The record of the key scheduled already
+// is moved to the new time.
 func (s *SimpleExpirer) Add(key sdk.KeyInfo) {
 
 	s.opsApiRequestsTotal.Inc()
-	s.opsRecsTotal.Inc()
 
-	// round expires time to the next sheduler tick
-	expires := (key.Expires/s.cfg.ShedulerDelExpiredEverySec + 1) * s.cfg.ShedulerDelExpiredEverySec
+	expires := s.roundUp(key.Expires)
 
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	s.timetable.Push(&schedHeapItem{
+	if item, ok := s.items[key.Key]; ok {
+		item.priority = expires
+		item.expires = key.Expires
+		heap.Fix(&s.timetable, item.index)
+		return
+	}
+
+	item := &schedHeapItem{
 		value:    key.Key,
 		priority: expires,
-	})
+		expires:  key.Expires,
+	}
+
+	heap.Push(&s.timetable, item)
+	s.items[key.Key] = item
+	s.opsRecsTotal.Set(float64(len(s.items)))
+}
+
+// Cancel removes the scheduled record of the key expiring not later
+// than key.Expires. The later one belongs to the record stored after it.
+func (s *SimpleExpirer) Cancel(key sdk.KeyInfo) {
+
+	s.opsApiRequestsTotal.Inc()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if item, ok := s.items[key.Key]; ok && item.expires <= key.Expires {
+		heap.Remove(&s.timetable, item.index)
+		delete(s.items, key.Key)
+		s.opsRecsTotal.Set(float64(len(s.items)))
+	}
+}
+
+// roundUp rounds expires time to the next sheduler tick
+func (s *SimpleExpirer) roundUp(expires int64) int64 {
+	tick := s.cfg.ShedulerDelExpiredEverySec * 1000
+	return (expires/tick + 1) * tick
 }
 
 func (s *SimpleExpirer) Start() {
@@ -102,26 +159,67 @@ func (s *SimpleExpirer) Close() {
 	s.done <- true
 }
 
-func (s *SimpleExpirer) GetChan() *chan sdk.KeyInfo {
+func (s *SimpleExpirer) GetChan() *chan []sdk.KeyInfo {
 	return &s.C
 }
 
-func (s *SimpleExpirer) tick() {
+// Flush cancels all scheduled records of namespace or all scheduled records
+// at all if namespace is empty.
+func (s *SimpleExpirer) Flush(namespace string) {
 
-	s.opsTriggeredTotal.Inc()
+	s.opsApiRequestsTotal.Inc()
 
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	t := time.Now().Unix()
+	if namespace == "" {
+		s.timetable = make(SchedMinHeap, 0)
+		s.items = make(map[string]*schedHeapItem)
+	} else {
+		n := 0
+		for _, item := range s.timetable {
+			if !sdk.InNamespace(item.value, namespace) {
+				item.index = n
+				s.timetable[n] = item
+				n++
+			} else {
+				delete(s.items, item.value)
+			}
+		}
+
+		for i := n; i < len(s.timetable); i++ {
+			s.timetable[i] = nil // avoid memory leak
+		}
+		s.timetable = s.timetable[:n]
+	}
+
+	heap.Init(&s.timetable)
+	s.opsRecsTotal.Set(float64(len(s.items)))
+}
+
+// tick sends the expired records to the channel. The lock isn't held while
+// they are sent and records the channel has no room for or over
+// sheduler_max_expired_per_tick are sent next time.
+func (s *SimpleExpirer) tick() {
+
+	s.opsTriggeredTotal.Inc()
+
+	s.m.Lock()
+	t := sdk.NowMs()
 	for (s.timetable.Len() > 0) && (s.timetable[0].priority <= t) {
 		item := heap.Pop(&s.timetable).(*schedHeapItem)
+		delete(s.items, item.value)
 
-		s.C <- sdk.KeyInfo{
-			Expires: item.priority,
+		s.pending = append(s.pending, sdk.KeyInfo{
+			Expires: item.expires,
 			Key:     item.value,
-		}
-
-		s.opsRecsTotal.Dec()
+		})
 	}
+	s.opsRecsTotal.Set(float64(len(s.items)))
+	s.m.Unlock()
+
+	wasFull := s.full
+	s.pending, s.full = deliver(s.C, s.pending, int(s.cfg.ShedulerBatchSize), int(s.cfg.ShedulerMaxExpiredPerTick))
+	s.opsPending.Set(float64(len(s.pending)))
+	logPending(s.C, wasFull, s.full, len(s.pending))
 }
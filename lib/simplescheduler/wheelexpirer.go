@@ -0,0 +1,203 @@
+package simplescheduler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Expirer is the scheduler running in background
+type Expirer interface {
+	sdk.Scheduler
+	Start()
+	Close()
+}
+
+// NewExpirer returns the scheduler chosen by sheduler_type
+func NewExpirer(cfg *config.Config) (Expirer, error) {
+
+	switch cfg.ShedulerType {
+	case config.ShedulerHeap:
+		return NewSimpleExpirer(cfg), nil
+	case config.ShedulerWheel:
+		return NewWheelExpirer(cfg), nil
+	}
+
+	return nil, fmt.Errorf("unknown scheduler '%s'", cfg.ShedulerType)
+}
+
+// WheelExpirer keeps scheduled records in the hierarchical timing wheel
+// turned every sheduler_tick_ms. Records are expired within a tick after
+// their expiration time. Every key is scheduled once. Expired records
+// are sent in batches of sheduler_batch_size.
+type WheelExpirer struct {
+	C                   chan []sdk.KeyInfo
+	cfg                 *config.Config
+	done                chan bool
+	m                   sync.Mutex
+	opsApiRequestsTotal prometheus.Counter
+	opsPending          prometheus.Gauge
+	opsRecsTotal        prometheus.Gauge
+	opsTriggeredTotal   prometheus.Counter
+	full                bool          // the queue had no room on the last tick
+	pending             []sdk.KeyInfo // expired records not sent yet
+	tickMs              int64
+	timer               *time.Ticker
+	wheel               *timingWheel
+}
+
+func NewWheelExpirer(cfg *config.Config) *WheelExpirer {
+
+	return newWheelExpirer(cfg, prometheus.DefaultRegisterer)
+}
+
+// newWheelExpirer creates the expirer with the metrics registered in reg
+func newWheelExpirer(cfg *config.Config, reg prometheus.Registerer) *WheelExpirer {
+
+	factory := promauto.With(reg)
+	x := &WheelExpirer{
+		C:      make(chan []sdk.KeyInfo, queueBatches(cfg)),
+		cfg:    cfg,
+		done:   make(chan bool),
+		tickMs: cfg.ShedulerTickMs,
+
+		opsApiRequestsTotal: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "api_requests_total",
+			Help:      "The total number of requests to scheduler API",
+		}),
+
+		opsPending: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "pending",
+			Help:      "The number of expired records waiting for room in the queue",
+		}),
+
+		opsRecsTotal: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "records_total",
+			Help:      "The number of records are sheduled for expiring",
+		}),
+
+		opsTriggeredTotal: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "triggered_total",
+			Help:      "The number of times sheduled is triggered",
+		}),
+		timer: time.NewTicker(time.Duration(cfg.ShedulerTickMs) * time.Millisecond),
+	}
+
+	x.wheel = newTimingWheel(x.tickOf(time.Now()))
+
+	x.opsApiRequestsTotal.Add(0.0)
+	x.opsPending.Set(0.0)
+	x.opsRecsTotal.Set(0.0)
+	x.opsTriggeredTotal.Add(0.0)
+
+	return x
+}
+
+// tickOf returns the tick of the moment
+func (s *WheelExpirer) tickOf(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond) / s.tickMs
+}
+
+// deadlineOf returns the first tick not before the expiration time
+func (s *WheelExpirer) deadlineOf(expires int64) int64 {
+	return (expires + s.tickMs - 1) / s.tickMs
+}
+
+// Add schedules the record. The record of the key scheduled already
+// is moved to the new time.
+func (s *WheelExpirer) Add(key sdk.KeyInfo) {
+
+	s.opsApiRequestsTotal.Inc()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.wheel.add(key.Key, key.Expires, s.deadlineOf(key.Expires))
+	s.opsRecsTotal.Set(float64(s.wheel.Len()))
+}
+
+// Cancel removes the scheduled record of the key expiring not later
+// than key.Expires. The later one belongs to the record stored after it.
+func (s *WheelExpirer) Cancel(key sdk.KeyInfo) {
+
+	s.opsApiRequestsTotal.Inc()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.wheel.remove(key.Key, key.Expires) {
+		s.opsRecsTotal.Dec()
+	}
+}
+
+func (s *WheelExpirer) Start() {
+
+	defer s.timer.Stop()
+
+	for {
+		select {
+		case t := <-s.timer.C:
+			s.tick(t)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *WheelExpirer) Close() {
+	s.done <- true
+}
+
+func (s *WheelExpirer) GetChan() *chan []sdk.KeyInfo {
+	return &s.C
+}
+
+// Flush cancels all scheduled records of namespace or all scheduled records
+// at all if namespace is empty.
+func (s *WheelExpirer) Flush(namespace string) {
+
+	s.opsApiRequestsTotal.Inc()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.wheel.flush(namespace)
+	s.opsRecsTotal.Set(float64(s.wheel.Len()))
+}
+
+// tick turns the wheel up to the moment t catching up the ticks missed
+// by the ticker and sends the expired records to the channel. The lock
+// isn't held while they are sent and records the channel has no room for
+// are sent next time as well as records over sheduler_max_expired_per_tick.
+func (s *WheelExpirer) tick(t time.Time) {
+
+	s.opsTriggeredTotal.Inc()
+
+	s.m.Lock()
+	for target := s.tickOf(t); s.wheel.now < target; {
+		s.wheel.advance(func(x *wheelItem) {
+			s.pending = append(s.pending, sdk.KeyInfo{Expires: x.expires, Key: x.value})
+		})
+	}
+	s.opsRecsTotal.Set(float64(s.wheel.Len()))
+	s.m.Unlock()
+
+	wasFull := s.full
+	s.pending, s.full = deliver(s.C, s.pending, int(s.cfg.ShedulerBatchSize), int(s.cfg.ShedulerMaxExpiredPerTick))
+	s.opsPending.Set(float64(len(s.pending)))
+	logPending(s.C, wasFull, s.full, len(s.pending))
+}
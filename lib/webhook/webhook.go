@@ -0,0 +1,327 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsSubsystem = "webhook"
+
+// Reasons of dropped notifications
+const (
+	dropQueueFull = "queue_full"
+	dropFailed    = "failed"
+)
+
+// Notification is the event of the key leaving the cache. Key of the flush
+// event is the flushed namespace, empty if all keys are flushed.
+type Notification struct {
+	Event  string `json:"event"`
+	Key    string `json:"key"`
+	TimeMs int64  `json:"time_ms"` // unix time in milliseconds
+}
+
+// payload is the body of requests to webhooks
+type payload struct {
+	Notifications []Notification `json:"notifications"`
+}
+
+// sink is the webhook with its own queue of notifications
+type sink struct {
+	hook   config.Webhook
+	events map[string]bool // all events are notified if empty
+	queue  chan Notification
+}
+
+func (s *sink) match(n Notification) bool {
+
+	if len(s.events) > 0 && !s.events[n.Event] {
+		return false
+	}
+
+	// the key of the flush is the namespace which could hold keys of the prefix
+	if n.Event == config.WebhookFlush {
+		namespace := n.Key + sdk.NamespaceSeparator
+		return n.Key == "" || strings.HasPrefix(namespace, s.hook.Prefix) || strings.HasPrefix(s.hook.Prefix, namespace)
+	}
+
+	return strings.HasPrefix(n.Key, s.hook.Prefix)
+}
+
+// Webhooks sends notifications about expired, evicted, deleted and flushed keys
+// to the configured webhooks. Every webhook has the queue of
+// webhook_queue_size notifications sent in batches of webhook_batch_size
+// or every webhook_flush_ms. Failed requests are retried webhook_retries
+// times with the doubling delay starting at webhook_retry_ms. Notifications
+// are dropped rather than blocking the cache when the queue is full.
+type Webhooks struct {
+	cfg      *config.Config
+	client   *http.Client
+	done     chan bool
+	stopped  chan bool
+	stopping chan bool // closed to stop the workers
+	sinks    []*sink
+	wg       sync.WaitGroup
+
+	opsDroppedTotal       *prometheus.CounterVec
+	opsErrorsTotal        prometheus.Counter
+	opsNotificationsTotal prometheus.Counter
+}
+
+func NewWebhooks(cfg *config.Config) *Webhooks {
+
+	return newWebhooks(cfg, prometheus.DefaultRegisterer)
+}
+
+// newWebhooks creates the webhooks with the metrics registered in reg
+func newWebhooks(cfg *config.Config, reg prometheus.Registerer) *Webhooks {
+
+	factory := promauto.With(reg)
+	w := &Webhooks{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: time.Duration(cfg.WebhookTimeoutMs) * time.Millisecond},
+		done:     make(chan bool),
+		stopped:  make(chan bool),
+		stopping: make(chan bool),
+
+		opsDroppedTotal: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: sdk.MetricsNamespace,
+				Subsystem: metricsSubsystem,
+				Name:      "dropped_total",
+				Help:      "The total number of dropped notifications grouped by reason",
+			},
+			[]string{"reason"},
+		),
+
+		opsErrorsTotal: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "errors_total",
+			Help:      "The total number of failed requests to webhooks",
+		}),
+
+		opsNotificationsTotal: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "notifications_total",
+			Help:      "The total number of notifications delivered to webhooks",
+		}),
+	}
+
+	for _, hook := range cfg.Webhooks {
+		s := &sink{
+			hook:   hook,
+			events: make(map[string]bool),
+			queue:  make(chan Notification, cfg.WebhookQueueSize),
+		}
+
+		for _, event := range hook.Events {
+			s.events[event] = true
+		}
+
+		w.sinks = append(w.sinks, s)
+	}
+
+	w.opsDroppedTotal.WithLabelValues(dropQueueFull).Add(0.0)
+	w.opsDroppedTotal.WithLabelValues(dropFailed).Add(0.0)
+	w.opsErrorsTotal.Add(0.0)
+	w.opsNotificationsTotal.Add(0.0)
+
+	return w
+}
+
+// Add notifies about keys deleted by clients and flushes of namespaces.
+// It makes Webhooks the consumer of the replication log.
+func (w *Webhooks) Add(item sdk.ReplItem) {
+
+	switch item.Action {
+	case sdk.ReplActionDelete:
+		w.notify(config.WebhookDelete, item.Key.Key)
+	case sdk.ReplActionFlush:
+		w.notify(config.WebhookFlush, item.Key.Key)
+	}
+}
+
+// Expired notifies about the record deleted by the scheduler or active
+// expiration. It should be passed to sdk.Cache watching the scheduler.
+func (w *Webhooks) Expired(key sdk.KeyInfo) {
+	w.notify(config.WebhookExpire, key.Key)
+}
+
+// Evicted notifies about the record deleted by the cache to free memory
+func (w *Webhooks) Evicted(key sdk.KeyInfo) {
+	w.notify(config.WebhookEvict, key.Key)
+}
+
+// notify queues the notification to every matching webhook without blocking
+func (w *Webhooks) notify(event, key string) {
+
+	if sdk.IsReserved(key) {
+		return
+	}
+
+	n := Notification{Event: event, Key: key, TimeMs: sdk.NowMs()}
+
+	for _, s := range w.sinks {
+		if !s.match(n) {
+			continue
+		}
+
+		select {
+		case s.queue <- n:
+		default:
+			w.opsDroppedTotal.WithLabelValues(dropQueueFull).Inc()
+		}
+	}
+}
+
+// Start runs the workers of all webhooks until Close is called
+func (w *Webhooks) Start() {
+
+	for _, s := range w.sinks {
+		w.wg.Add(1)
+		go w.run(s)
+	}
+
+	<-w.done
+	close(w.stopping)
+	w.wg.Wait()
+	close(w.stopped)
+}
+
+// Close sends the queued notifications once more and stops the workers
+func (w *Webhooks) Close() {
+	w.done <- true
+	<-w.stopped
+}
+
+func (w *Webhooks) run(s *sink) {
+
+	defer w.wg.Done()
+
+	timer := time.NewTicker(time.Duration(w.cfg.WebhookFlushMs) * time.Millisecond)
+	defer timer.Stop()
+
+	size := int(w.cfg.WebhookBatchSize)
+	batch := make([]Notification, 0, size)
+
+	for {
+		select {
+		case n := <-s.queue:
+			if batch = append(batch, n); len(batch) >= size {
+				w.send(s, batch)
+				batch = make([]Notification, 0, size)
+			}
+		case <-timer.C:
+			if len(batch) > 0 {
+				w.send(s, batch)
+				batch = make([]Notification, 0, size)
+			}
+		case <-w.stopping:
+			w.drain(s, batch)
+			return
+		}
+	}
+}
+
+// drain sends the batch and the queued notifications without retries
+func (w *Webhooks) drain(s *sink, batch []Notification) {
+
+	size := int(w.cfg.WebhookBatchSize)
+
+	for {
+		select {
+		case n := <-s.queue:
+			if batch = append(batch, n); len(batch) >= size {
+				w.send(s, batch)
+				batch = make([]Notification, 0, size)
+			}
+		default:
+			if len(batch) > 0 {
+				w.send(s, batch)
+			}
+			return
+		}
+	}
+}
+
+// send posts the batch to the webhook retrying failed requests until
+// the workers are stopped. The batch is dropped if all attempts fail.
+func (w *Webhooks) send(s *sink, batch []Notification) {
+
+	delay := time.Duration(w.cfg.WebhookRetryMs) * time.Millisecond
+
+	for attempt := int64(0); ; attempt++ {
+		retry, err := w.post(s.hook.URL, batch)
+		if err == nil {
+			w.opsNotificationsTotal.Add(float64(len(batch)))
+			return
+		}
+
+		w.opsErrorsTotal.Inc()
+
+		if !retry || attempt == w.cfg.WebhookRetries || w.isStopping() {
+			log.Printf("webhook %s error: %s. %d notifications are dropped.", s.hook.URL, err.Error(), len(batch))
+			w.opsDroppedTotal.WithLabelValues(dropFailed).Add(float64(len(batch)))
+			return
+		}
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-w.stopping:
+			// the last attempt before the workers stop
+		}
+	}
+}
+
+func (w *Webhooks) isStopping() bool {
+
+	select {
+	case <-w.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// post sends the batch to url and reports whether the failed request
+// is worth retrying
+func (w *Webhooks) post(url string, batch []Notification) (bool, error) {
+
+	body, err := json.Marshal(payload{Notifications: batch})
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := w.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	// read the body to reuse the connection
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+}
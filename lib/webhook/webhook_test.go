@@ -0,0 +1,206 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// receiver is the webhook responding with the statuses in order
+// and then with 200 OK
+type receiver struct {
+	m        sync.Mutex
+	statuses []int
+	requests int
+	batches  [][]Notification
+}
+
+func (x *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	x.m.Lock()
+	defer x.m.Unlock()
+
+	x.requests++
+	if len(x.statuses) > 0 {
+		w.WriteHeader(x.statuses[0])
+		x.statuses = x.statuses[1:]
+		return
+	}
+
+	var body payload
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	x.batches = append(x.batches, body.Notifications)
+}
+
+func (x *receiver) keys() map[string]string {
+
+	x.m.Lock()
+	defer x.m.Unlock()
+
+	keys := make(map[string]string)
+	for _, batch := range x.batches {
+		for _, n := range batch {
+			keys[n.Key] = n.Event
+		}
+	}
+
+	return keys
+}
+
+// newTestWebhooks returns Webhooks sending to the receivers in order
+func newTestWebhooks(hooks []config.Webhook, receivers ...*receiver) (*Webhooks, func()) {
+
+	var servers []*httptest.Server
+	for i, x := range receivers {
+		srv := httptest.NewServer(x)
+		servers = append(servers, srv)
+		hooks[i].URL = srv.URL
+	}
+
+	cfg := *config.GetConfig()
+	cfg.Webhooks = hooks
+	cfg.WebhookFlushMs = 10
+	cfg.WebhookRetryMs = 1
+
+	w := newWebhooks(&cfg, prometheus.NewRegistry())
+	go w.Start()
+
+	return w, func() {
+		w.Close()
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+
+	all, users := &receiver{}, &receiver{}
+	w, stop := newTestWebhooks([]config.Webhook{
+		{},
+		{Prefix: "users/", Events: []string{config.WebhookExpire, config.WebhookEvict}},
+	}, all, users)
+
+	w.Expired(sdk.KeyInfo{Key: "users/1"})
+	w.Evicted(sdk.KeyInfo{Key: "users/2"})
+	w.Add(*sdk.NewReplItem(sdk.ReplActionDelete, sdk.KeyInfo{Key: "users/3"}, sdk.Record{}))
+	w.Add(*sdk.NewReplItem(sdk.ReplActionDelete, sdk.KeyInfo{Key: "posts/1"}, sdk.Record{}))
+	w.Add(*sdk.NewReplItem(sdk.ReplActionInsert, sdk.KeyInfo{Key: "posts/2"}, sdk.Record{}))
+	w.Add(*sdk.NewReplItem(sdk.ReplActionFlush, sdk.KeyInfo{Key: "posts"}, sdk.Record{}))
+	w.Expired(sdk.KeyInfo{Key: "_lock/a"})
+
+	stop()
+
+	wants := map[string]string{
+		"users/1": config.WebhookExpire,
+		"users/2": config.WebhookEvict,
+		"users/3": config.WebhookDelete,
+		"posts/1": config.WebhookDelete,
+		"posts":   config.WebhookFlush,
+	}
+
+	if keys := all.keys(); len(keys) != len(wants) {
+		t.Errorf("the webhook of all keys is notified about %v; wants %v", keys, wants)
+	} else {
+		for key, event := range wants {
+			if keys[key] != event {
+				t.Errorf("the webhook of all keys is notified about %s by %q; wants %q", key, keys[key], event)
+			}
+		}
+	}
+
+	if keys := users.keys(); len(keys) != 2 || keys["users/1"] == "" || keys["users/2"] == "" {
+		t.Errorf("the filtered webhook is notified about %v; wants users/1 and users/2", keys)
+	}
+
+	if n := testutil.ToFloat64(w.opsNotificationsTotal); n != 7 {
+		t.Errorf("%v notifications are delivered; wants 7", n)
+	}
+}
+
+func TestSinkMatchFlush(t *testing.T) {
+
+	table := []struct {
+		prefix    string
+		namespace string
+		wants     bool
+	}{
+		{"users/", "", true},
+		{"users/", "users", true},
+		{"users/1", "users", true},
+		{"us", "users", true},
+		{"users/", "posts", false},
+		{"users/", "user", false},
+	}
+
+	for _, x := range table {
+		s := &sink{hook: config.Webhook{Prefix: x.prefix}}
+		n := Notification{Event: config.WebhookFlush, Key: x.namespace}
+
+		if got := s.match(n); got != x.wants {
+			t.Errorf("match() of prefix %q and flush of %q = %v; wants %v", x.prefix, x.namespace, got, x.wants)
+		}
+	}
+}
+
+func TestWebhooksRetry(t *testing.T) {
+
+	flaky := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	broken := &receiver{statuses: []int{http.StatusBadRequest}}
+	w, stop := newTestWebhooks([]config.Webhook{{}, {}}, flaky, broken)
+
+	w.Expired(sdk.KeyInfo{Key: "a"})
+
+	// requests are retried until the workers are stopped
+	for i := 0; i < 100 && len(flaky.keys()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+
+	if flaky.requests != 3 || len(flaky.keys()) != 1 {
+		t.Errorf("%d requests delivered %v; wants a delivered by the third request", flaky.requests, flaky.keys())
+	}
+
+	// client errors are not retried
+	if broken.requests != 1 {
+		t.Errorf("%d requests are made to the broken webhook; wants 1", broken.requests)
+	}
+
+	if n := testutil.ToFloat64(w.opsErrorsTotal); n != 3 {
+		t.Errorf("%v requests failed; wants 3", n)
+	}
+
+	if n := testutil.ToFloat64(w.opsDroppedTotal.WithLabelValues(dropFailed)); n != 1 {
+		t.Errorf("%v notifications are dropped as failed; wants 1", n)
+	}
+}
+
+func TestWebhooksQueueFull(t *testing.T) {
+
+	cfg := *config.GetConfig()
+	cfg.Webhooks = []config.Webhook{{URL: "http://127.0.0.1:1/"}}
+	cfg.WebhookQueueSize = 2
+
+	// the workers aren't started so nothing leaves the queue
+	w := newWebhooks(&cfg, prometheus.NewRegistry())
+	for _, key := range []string{"a", "b", "c", "d"} {
+		w.Expired(sdk.KeyInfo{Key: key})
+	}
+
+	if n := testutil.ToFloat64(w.opsDroppedTotal.WithLabelValues(dropQueueFull)); n != 2 {
+		t.Errorf("%v notifications are dropped by the full queue; wants 2", n)
+	}
+}
@@ -0,0 +1,213 @@
+package writebehind
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// Operations of the journal
+const (
+	journalSet   = "set"
+	journalDel   = "del"
+	journalFlush = "flush"
+)
+
+// journalEntry is a line of the journal, Key of the flush holds the namespace
+type journalEntry struct {
+	Op      string `json:"op"`
+	Key     string `json:"key"`
+	Expires int64  `json:"expires,omitempty"`
+	Flags   uint32 `json:"flags,omitempty"`
+	Value   []byte `json:"value,omitempty"`
+}
+
+// FileBackend keeps keys in the append-only journal of JSON lines.
+// Every batch is appended and synced to disk at once. The journal is
+// compacted to the latest values of keys when the backend is opened.
+type FileBackend struct {
+	m    sync.Mutex
+	file *os.File
+	path string
+}
+
+func NewFileBackend(path string) (*FileBackend, error) {
+
+	items, err := readJournal(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = compactJournal(path, items); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileBackend{file: file, path: path}, nil
+}
+
+func (b *FileBackend) Write(items []sdk.BackendItem) error {
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	for _, x := range items {
+		entry := journalEntry{Op: journalDel, Key: x.Key}
+		if !x.Deleted {
+			entry = journalEntry{Op: journalSet, Key: x.Key, Expires: x.Expires, Flags: x.Flags, Value: x.Value}
+		}
+
+		if err := enc.Encode(&entry); err != nil {
+			return err
+		}
+	}
+
+	return b.append(buf.Bytes())
+}
+
+func (b *FileBackend) Flush(namespace string) error {
+
+	line, err := json.Marshal(journalEntry{Op: journalFlush, Key: namespace})
+	if err != nil {
+		return err
+	}
+
+	return b.append(append(line, '\n'))
+}
+
+func (b *FileBackend) append(data []byte) error {
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if _, err := b.file.Write(data); err != nil {
+		return err
+	}
+
+	return b.file.Sync()
+}
+
+func (b *FileBackend) Load(fn func(item sdk.BackendItem)) error {
+
+	b.m.Lock()
+	items, err := readJournal(b.path)
+	b.m.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, x := range items {
+		fn(x)
+	}
+
+	return nil
+}
+
+func (b *FileBackend) Close() error {
+	return b.file.Close()
+}
+
+// readJournal replays the journal and returns the latest values of keys.
+// The absent journal is empty. The last line without the line feed is
+// the write interrupted by a crash so it's skipped.
+func readJournal(path string) (map[string]sdk.BackendItem, error) {
+
+	items := make(map[string]sdk.BackendItem)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return items, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rd := bufio.NewReader(file)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			return items, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		var entry journalEntry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			return nil, err
+		}
+
+		switch entry.Op {
+		case journalSet:
+			items[entry.Key] = sdk.BackendItem{
+				Key:     entry.Key,
+				Expires: entry.Expires,
+				Flags:   entry.Flags,
+				Value:   entry.Value,
+			}
+		case journalDel:
+			delete(items, entry.Key)
+		case journalFlush:
+			for k := range items {
+				if sdk.InNamespace(k, entry.Key) {
+					delete(items, k)
+				}
+			}
+		}
+	}
+}
+
+// compactJournal replaces the journal by the one setting every key
+// not expired yet once
+func compactJournal(path string, items map[string]sdk.BackendItem) error {
+
+	now := sdk.NowMs()
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	wr := bufio.NewWriter(file)
+	enc := json.NewEncoder(wr)
+
+	for _, x := range items {
+		if x.Expires <= now {
+			continue
+		}
+
+		entry := journalEntry{Op: journalSet, Key: x.Key, Expires: x.Expires, Flags: x.Flags, Value: x.Value}
+		if err = enc.Encode(&entry); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = wr.Flush()
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if e := file.Close(); err == nil {
+		err = e
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
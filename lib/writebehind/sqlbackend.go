@@ -0,0 +1,141 @@
+package writebehind
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+)
+
+// SqlBackend keeps keys in the table of the database. Every batch is
+// written in one transaction. Queries use ? placeholders like SQLite
+// and MySQL do.
+type SqlBackend struct {
+	db    *sql.DB
+	table string
+}
+
+// OpenSqlBackend opens the database with the driver registered
+// by the program and creates the table if it doesn't exist
+func OpenSqlBackend(driver, dsn, table string) (*SqlBackend, error) {
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := NewSqlBackend(db, table)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// NewSqlBackend creates the table if it doesn't exist. The table name
+// is not quoted so it must be a plain SQL name.
+func NewSqlBackend(db *sql.DB, table string) (*SqlBackend, error) {
+
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		cache_key VARCHAR(1024) NOT NULL PRIMARY KEY,
+		value BLOB,
+		flags INTEGER NOT NULL,
+		expires BIGINT NOT NULL
+	)`, table))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SqlBackend{db: db, table: table}, nil
+}
+
+func (b *SqlBackend) Write(items []sdk.BackendItem) error {
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = b.write(tx, items); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// write replaces keys by delete and insert which works with any database
+func (b *SqlBackend) write(tx *sql.Tx, items []sdk.BackendItem) error {
+
+	del, err := tx.Prepare(fmt.Sprintf("DELETE FROM %s WHERE cache_key = ?", b.table))
+	if err != nil {
+		return err
+	}
+	defer del.Close()
+
+	ins, err := tx.Prepare(fmt.Sprintf(
+		"INSERT INTO %s (cache_key, value, flags, expires) VALUES (?, ?, ?, ?)", b.table))
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+
+	for _, x := range items {
+		if _, err = del.Exec(x.Key); err != nil {
+			return err
+		}
+
+		if x.Deleted {
+			continue
+		}
+
+		if _, err = ins.Exec(x.Key, x.Value, x.Flags, x.Expires); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *SqlBackend) Flush(namespace string) error {
+
+	if namespace == "" {
+		_, err := b.db.Exec(fmt.Sprintf("DELETE FROM %s", b.table))
+		return err
+	}
+
+	// keys of the namespace are between "namespace/" and "namespace0"
+	// because '0' follows '/' in ASCII
+	_, err := b.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE cache_key >= ? AND cache_key < ?", b.table),
+		namespace+sdk.NamespaceSeparator,
+		namespace+"0",
+	)
+
+	return err
+}
+
+func (b *SqlBackend) Load(fn func(item sdk.BackendItem)) error {
+
+	rows, err := b.db.Query(fmt.Sprintf("SELECT cache_key, value, flags, expires FROM %s", b.table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item sdk.BackendItem
+		if err = rows.Scan(&item.Key, &item.Value, &item.Flags, &item.Expires); err != nil {
+			return err
+		}
+
+		fn(item)
+	}
+
+	return rows.Err()
+}
+
+func (b *SqlBackend) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,306 @@
+package writebehind
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsSubsystem = "writebehind"
+
+// WriteBehind consumes the replication log and writes the changes of plain
+// values to the backend in batches. Expired keys are deleted from the backend
+// by Expired. Only the latest change of a key is kept
+// in the batch. The batch is written when it's full or every
+// write_behind_flush_ms and written again on the next tick after an error.
+type WriteBehind struct {
+	backend sdk.Backend
+	cfg     *config.Config
+	done    chan bool
+	stopped chan bool
+	items   chan sdk.ReplItem
+	timer   *time.Ticker
+
+	batch   []sdk.BackendItem
+	indexes map[string]int // positions of keys in the batch
+	flushes []string       // namespaces flushed before the batch
+	failing bool
+
+	opsBatchesTotal prometheus.Counter
+	opsDroppedTotal prometheus.Counter
+	opsErrorsTotal  prometheus.Counter
+	opsItemsTotal   prometheus.Counter
+	opsPending      prometheus.Gauge
+}
+
+func NewWriteBehind(cfg *config.Config, backend sdk.Backend) *WriteBehind {
+
+	return newWriteBehind(cfg, backend, prometheus.DefaultRegisterer)
+}
+
+// newWriteBehind creates the write-behind with the metrics registered in reg
+func newWriteBehind(cfg *config.Config, backend sdk.Backend, reg prometheus.Registerer) *WriteBehind {
+
+	factory := promauto.With(reg)
+	d := time.Duration(cfg.WriteBehindFlushMs) * time.Millisecond
+	wb := &WriteBehind{
+		backend: backend,
+		cfg:     cfg,
+		done:    make(chan bool),
+		stopped: make(chan bool),
+		items:   make(chan sdk.ReplItem, cfg.WriteBehindQueueSize),
+		timer:   time.NewTicker(d),
+		indexes: make(map[string]int),
+
+		opsBatchesTotal: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "batches_total",
+			Help:      "The total number of batches written to the backend",
+		}),
+
+		opsDroppedTotal: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "dropped_total",
+			Help:      "The total number of changes of keys dropped because the queue is full",
+		}),
+
+		opsErrorsTotal: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "errors_total",
+			Help:      "The total number of failed writes to the backend",
+		}),
+
+		opsItemsTotal: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "items_total",
+			Help:      "The total number of changes of keys written to the backend",
+		}),
+
+		opsPending: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: sdk.MetricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "pending",
+			Help:      "The number of changes of keys waiting to be written to the backend",
+		}),
+	}
+
+	wb.opsBatchesTotal.Add(0.0)
+	wb.opsDroppedTotal.Add(0.0)
+	wb.opsErrorsTotal.Add(0.0)
+	wb.opsItemsTotal.Add(0.0)
+	wb.opsPending.Set(0.0)
+
+	return wb
+}
+
+// Add queues the change without blocking. The change is dropped if the queue
+// is full so the slow backend doesn't slow down the cache. The backend misses
+// the dropped change until the key is changed again.
+func (wb *WriteBehind) Add(item sdk.ReplItem) {
+
+	select {
+	case wb.items <- item:
+	default:
+		wb.opsDroppedTotal.Inc()
+	}
+}
+
+// Expired queues the deletion of the record deleted by the scheduler or active
+// expiration. It should be passed to sdk.Cache watching the scheduler.
+func (wb *WriteBehind) Expired(key sdk.KeyInfo) {
+	wb.Add(*sdk.NewReplItem(sdk.ReplActionExpire, key, sdk.Record{}))
+}
+
+func (wb *WriteBehind) Start() {
+
+	defer wb.timer.Stop()
+
+	for {
+		select {
+		case item := <-wb.items:
+			wb.add(item)
+			if int64(len(wb.batch)) >= wb.cfg.WriteBehindBatchSize && !wb.failing {
+				wb.write()
+			}
+		case <-wb.timer.C:
+			wb.write()
+		case <-wb.done:
+			wb.drain()
+			close(wb.stopped)
+			return
+		}
+	}
+}
+
+// Close writes the queued changes and stops the worker
+func (wb *WriteBehind) Close() {
+	wb.done <- true
+	<-wb.stopped
+}
+
+// drain writes the queued changes once more
+func (wb *WriteBehind) drain() {
+
+	for {
+		select {
+		case item := <-wb.items:
+			wb.add(item)
+		default:
+			wb.write()
+			return
+		}
+	}
+}
+
+func (wb *WriteBehind) add(item sdk.ReplItem) {
+
+	if sdk.IsReserved(item.Key.Key) && item.Action != sdk.ReplActionFlush {
+		return
+	}
+
+	switch item.Action {
+	case sdk.ReplActionInsert:
+		if !item.Value.IsPlain() {
+			// the key doesn't hold the plain value written before anymore
+			wb.set(sdk.BackendItem{Key: item.Key.Key, Deleted: true})
+			break
+		}
+
+		wb.set(sdk.BackendItem{
+			Key:     item.Key.Key,
+			Expires: item.Value.Expires,
+			Flags:   item.Value.Flags,
+			Value:   item.Value.Value,
+		})
+
+	case sdk.ReplActionDelete, sdk.ReplActionExpire:
+		wb.set(sdk.BackendItem{Key: item.Key.Key, Deleted: true})
+
+	case sdk.ReplActionFlush:
+		// the flushed changes are not written at all
+		namespace := item.Key.Key
+		batch := wb.batch[:0]
+		wb.indexes = make(map[string]int)
+
+		for _, x := range wb.batch {
+			if !sdk.InNamespace(x.Key, namespace) {
+				wb.indexes[x.Key] = len(batch)
+				batch = append(batch, x)
+			}
+		}
+
+		wb.batch = batch
+		wb.flushes = append(wb.flushes, namespace)
+	}
+
+	wb.opsPending.Set(float64(len(wb.batch)))
+}
+
+func (wb *WriteBehind) set(item sdk.BackendItem) {
+
+	if i, ok := wb.indexes[item.Key]; ok {
+		wb.batch[i] = item
+		return
+	}
+
+	wb.indexes[item.Key] = len(wb.batch)
+	wb.batch = append(wb.batch, item)
+}
+
+// write applies the flushes and writes the batch to the backend
+func (wb *WriteBehind) write() {
+
+	for len(wb.flushes) > 0 {
+		if err := wb.backend.Flush(wb.flushes[0]); err != nil {
+			wb.fail(fmt.Errorf("flush of '%s': %s", wb.flushes[0], err.Error()))
+			return
+		}
+
+		wb.flushes = wb.flushes[1:]
+	}
+
+	if len(wb.batch) == 0 {
+		wb.failing = false
+		return
+	}
+
+	if err := wb.backend.Write(wb.batch); err != nil {
+		wb.fail(err)
+		return
+	}
+
+	wb.failing = false
+	wb.opsBatchesTotal.Inc()
+	wb.opsItemsTotal.Add(float64(len(wb.batch)))
+	wb.opsPending.Set(0.0)
+
+	wb.batch = make([]sdk.BackendItem, 0, len(wb.batch))
+	wb.indexes = make(map[string]int)
+}
+
+func (wb *WriteBehind) fail(err error) {
+
+	log.Printf("write-behind error: %s. %d changes are kept to be written again.",
+		err.Error(),
+		len(wb.batch),
+	)
+
+	wb.failing = true
+	wb.opsErrorsTotal.Inc()
+}
+
+// Restore inserts the keys of the backend not expired yet into the cache
+// and schedules their expiration. The keys expired while the server was
+// stopped are deleted from the backend. The restored keys are not replicated.
+// It returns the number of restored keys.
+func Restore(backend sdk.Backend, cache sdk.Cache, sched sdk.Scheduler) (int, error) {
+
+	now := sdk.NowMs()
+	n := 0
+	var expired []sdk.BackendItem
+
+	err := backend.Load(func(item sdk.BackendItem) {
+
+		if item.Expires <= now {
+			expired = append(expired, sdk.BackendItem{Key: item.Key, Deleted: true})
+			return
+		}
+
+		keyinfo := sdk.KeyInfo{Expires: item.Expires, Key: item.Key}
+		rec := sdk.NewRecord(item.Expires, item.Value)
+		rec.Flags = item.Flags
+
+		cache.Insert(keyinfo, *rec)
+		sched.Add(keyinfo)
+		n++
+	})
+
+	if err == nil && len(expired) > 0 {
+		err = backend.Write(expired)
+	}
+
+	return n, err
+}
+
+// OpenBackend opens the backend chosen by write_behind_backend
+func OpenBackend(cfg *config.Config) (sdk.Backend, error) {
+
+	switch cfg.WriteBehindBackend {
+	case config.WriteBehindFile:
+		return NewFileBackend(cfg.WriteBehindPath)
+	case config.WriteBehindSql:
+		return OpenSqlBackend(cfg.WriteBehindSqlDriver, cfg.WriteBehindPath, cfg.WriteBehindSqlTable)
+	}
+
+	return nil, fmt.Errorf("unknown write-behind backend '%s'", cfg.WriteBehindBackend)
+}
@@ -0,0 +1,236 @@
+package writebehind
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testBackend keeps keys in memory and fails writes while fail is set
+type testBackend struct {
+	data    map[string]sdk.BackendItem
+	batches int
+	fail    bool
+}
+
+func (b *testBackend) Write(items []sdk.BackendItem) error {
+
+	if b.fail {
+		return errors.New("backend is down")
+	}
+
+	for _, x := range items {
+		if x.Deleted {
+			delete(b.data, x.Key)
+		} else {
+			b.data[x.Key] = x
+		}
+	}
+
+	b.batches++
+	return nil
+}
+
+func (b *testBackend) Flush(namespace string) error {
+
+	for k := range b.data {
+		if sdk.InNamespace(k, namespace) {
+			delete(b.data, k)
+		}
+	}
+
+	return nil
+}
+
+func (b *testBackend) Load(fn func(item sdk.BackendItem)) error {
+
+	for _, x := range b.data {
+		fn(x)
+	}
+
+	return nil
+}
+
+func (b *testBackend) Close() error { return nil }
+
+func insertItem(key, value string) sdk.ReplItem {
+	return *sdk.NewReplItem(sdk.ReplActionInsert, sdk.KeyInfo{Key: key},
+		*sdk.NewRecord(sdk.NowMs()+60000, []byte(value)))
+}
+
+func TestWriteBehind(t *testing.T) {
+
+	cfg := *config.GetConfig()
+	cfg.WriteBehindBatchSize = 3
+	cfg.WriteBehindFlushMs = 3600 * 1000
+
+	backend := &testBackend{data: make(map[string]sdk.BackendItem)}
+	wb := newWriteBehind(&cfg, backend, prometheus.NewRegistry())
+	go wb.Start()
+
+	backend.data["users/old"] = sdk.BackendItem{Key: "users/old"}
+	backend.data["h"] = sdk.BackendItem{Key: "h", Value: []byte("plain")}
+
+	hash := *sdk.NewReplItem(sdk.ReplActionInsert, sdk.KeyInfo{Key: "h"},
+		sdk.Record{Expires: sdk.NowMs() + 60000, Hash: sdk.NewHash()})
+
+	for _, x := range []sdk.ReplItem{
+		insertItem("a", "1"),
+		insertItem("a", "2"),
+		insertItem("_lock/a", "token"),
+		insertItem("users/1", "x"),
+		*sdk.NewReplItem(sdk.ReplActionFlush, sdk.KeyInfo{Key: "users"}, sdk.Record{}),
+		insertItem("users/2", "y"),
+		insertItem("b", "1"),
+		*sdk.NewReplItem(sdk.ReplActionDelete, sdk.KeyInfo{Key: "b"}, sdk.Record{}),
+		hash,
+		insertItem("e", "1"),
+	} {
+		wb.Add(x)
+	}
+
+	wb.Expired(sdk.KeyInfo{Key: "e"})
+
+	wb.Close()
+
+	if len(backend.data) != 2 || string(backend.data["a"].Value) != "2" || string(backend.data["users/2"].Value) != "y" {
+		t.Errorf("backend = %v; wants a=2 users/2=y without the replaced plain value of h", backend.data)
+	}
+
+	// the failed batch is kept and written again
+	backend.fail = true
+	wb.add(insertItem("c", "1"))
+	wb.write()
+
+	backend.fail = false
+	wb.write()
+
+	if _, ok := backend.data["c"]; !ok || len(wb.batch) != 0 {
+		t.Errorf("failed batch isn't written again")
+	}
+}
+
+func TestWriteBehindQueueFull(t *testing.T) {
+
+	cfg := *config.GetConfig()
+	cfg.WriteBehindQueueSize = 2
+
+	// the worker isn't started so nothing leaves the queue
+	wb := newWriteBehind(&cfg, &testBackend{data: make(map[string]sdk.BackendItem)}, prometheus.NewRegistry())
+	for _, key := range []string{"a", "b", "c", "d"} {
+		wb.Add(insertItem(key, "1"))
+	}
+
+	if n := testutil.ToFloat64(wb.opsDroppedTotal); n != 2 {
+		t.Errorf("%v changes are dropped by the full queue; wants 2", n)
+	}
+}
+
+// restoreCache and restoreScheduler record restored keys, other methods
+// are never called by Restore
+type restoreCache struct {
+	sdk.Cache
+	keys []string
+}
+
+func (c *restoreCache) Insert(key sdk.KeyInfo, rec sdk.Record) {
+	c.keys = append(c.keys, key.Key)
+}
+
+type restoreScheduler struct {
+	sdk.Scheduler
+	n int
+}
+
+func (s *restoreScheduler) Add(key sdk.KeyInfo) {
+	s.n++
+}
+
+func TestRestore(t *testing.T) {
+
+	now := sdk.NowMs()
+	backend := &testBackend{data: map[string]sdk.BackendItem{
+		"a":       {Key: "a", Expires: now + 60000, Value: []byte("1")},
+		"expired": {Key: "expired", Expires: now - 1, Value: []byte("2")},
+	}}
+
+	cache := &restoreCache{}
+	sched := &restoreScheduler{}
+
+	n, err := Restore(backend, cache, sched)
+	if err != nil || n != 1 || len(cache.keys) != 1 || cache.keys[0] != "a" || sched.n != 1 {
+		t.Errorf("Restore() = %d, %v restored %v; wants a", n, err, cache.keys)
+	}
+
+	// the keys expired while the server was stopped are deleted
+	if _, ok := backend.data["expired"]; ok || len(backend.data) != 1 {
+		t.Errorf("backend after Restore() = %v; wants a", backend.data)
+	}
+}
+
+func TestBackends(t *testing.T) {
+
+	dir := t.TempDir()
+
+	file, err := NewFileBackend(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatalf("NewFileBackend() error %s", err.Error())
+	}
+
+	sql, err := OpenSqlBackend("sqlite3", filepath.Join(dir, "db.sqlite"), "cacheman")
+	if err != nil {
+		t.Fatalf("OpenSqlBackend() error %s", err.Error())
+	}
+
+	expires := sdk.NowMs() + 60000
+	for _, b := range []sdk.Backend{file, sql} {
+
+		if err = b.Write([]sdk.BackendItem{
+			{Key: "a", Expires: expires, Flags: 7, Value: []byte("1")},
+			{Key: "users/1", Expires: expires, Value: []byte("x")},
+			{Key: "users2", Expires: expires, Value: []byte("y")},
+			{Key: "b", Expires: expires, Value: []byte("z")},
+		}); err != nil {
+			t.Fatalf("%T.Write() error %s", b, err.Error())
+		}
+
+		if err = b.Write([]sdk.BackendItem{{Key: "b", Deleted: true}}); err != nil {
+			t.Fatalf("%T.Write() error %s", b, err.Error())
+		}
+
+		if err = b.Flush("users"); err != nil {
+			t.Fatalf("%T.Flush() error %s", b, err.Error())
+		}
+
+		got := make(map[string]sdk.BackendItem)
+		b.Load(func(item sdk.BackendItem) {
+			got[item.Key] = item
+		})
+
+		if len(got) != 2 || got["a"].Flags != 7 || got["a"].Expires != expires || string(got["users2"].Value) != "y" {
+			t.Errorf("%T.Load() = %v; wants a and users2", b, got)
+		}
+
+		b.Close()
+	}
+
+	// the journal is compacted when it's opened again
+	file, err = NewFileBackend(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatalf("NewFileBackend() error %s", err.Error())
+	}
+	defer file.Close()
+
+	n := 0
+	file.Load(func(item sdk.BackendItem) { n++ })
+	if n != 2 {
+		t.Errorf("reopened journal has %d keys; wants 2", n)
+	}
+}
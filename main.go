@@ -4,12 +4,23 @@ import (
 	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/iaroslavscript/cacheman/lib/config"
+	"github.com/iaroslavscript/cacheman/lib/grpcserver"
+	"github.com/iaroslavscript/cacheman/lib/memcache"
+	"github.com/iaroslavscript/cacheman/lib/resp"
+	"github.com/iaroslavscript/cacheman/lib/sdk"
 	"github.com/iaroslavscript/cacheman/lib/server"
 	"github.com/iaroslavscript/cacheman/lib/simplecache"
 	"github.com/iaroslavscript/cacheman/lib/simplereplication"
 	"github.com/iaroslavscript/cacheman/lib/simplescheduler"
+	"github.com/iaroslavscript/cacheman/lib/webhook"
+	"github.com/iaroslavscript/cacheman/lib/writebehind"
+	// drivers of write-behind backend "sql" are registered here,
+	// e.g. _ "github.com/go-sql-driver/mysql" for write_behind_sql_driver "mysql"
 )
 
 func parseFlag() {
@@ -19,11 +30,32 @@ func parseFlag() {
 		"http server bind address.",
 	)
 
+	flag.StringVar(&cfg.RespBindAddr, "resp-bind", cfg.RespBindAddr,
+		"redis protocol server bind address. Disabled if empty.",
+	)
+
+	flag.StringVar(&cfg.GrpcBindAddr, "grpc-bind", cfg.GrpcBindAddr,
+		"grpc server bind address. Disabled if empty.",
+	)
+
+	flag.StringVar(&cfg.MemcacheBindAddr, "memcache-bind", cfg.MemcacheBindAddr,
+		"memcached protocol server bind address. Disabled if empty.",
+	)
+
 	flag.Parse()
 }
 
 func main() {
 
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run starts the server and stops it on SIGINT or SIGTERM. It returns
+// the error of any server after stopping the others.
+func run() error {
+
 	// get default config
 	cfg := config.GetConfig()
 
@@ -44,20 +76,116 @@ func main() {
 	}
 
 	cache := simplecache.NewSimpleCache()
-	sched := simplescheduler.NewSimpleExpirer(cfg)
+	sched, err := simplescheduler.NewExpirer(cfg)
+	if err != nil {
+		log.Fatal("error creating scheduler ", err.Error())
+	}
 	repl := simplereplication.NewSimpleReplication(cfg)
 
-	go repl.Start()
-	go sched.Start()
-	go cache.WatchSheduler(sched)
-
+	// deferred calls run in reverse order on shutdown: servers stop first,
+	// then webhooks and write-behind flush queued changes, the cache stops last
 	defer func() {
 		repl.Close()
 		cache.Close()
 		sched.Close()
 	}()
 
-	serv := server.NewServer(cfg, cache, repl, sched)
+	replication := sdk.MultiReplication{repl}
+
+	var wb *writebehind.WriteBehind
+	if cfg.WriteBehindBackend != config.WriteBehindNone {
+		backend, err := writebehind.OpenBackend(cfg)
+		if err != nil {
+			log.Fatal("error opening write-behind backend ", err.Error())
+		}
+		defer backend.Close()
+
+		n, err := writebehind.Restore(backend, cache, sched)
+		if err != nil {
+			log.Fatal("error restoring keys from write-behind backend ", err.Error())
+		}
+		log.Printf("restored %d keys from write-behind backend", n)
+
+		wb = writebehind.NewWriteBehind(cfg, backend)
+		go wb.Start()
+		defer wb.Close()
+
+		replication = append(replication, wb)
+	}
+
+	var hooks *webhook.Webhooks
+	if len(cfg.Webhooks) > 0 {
+		hooks = webhook.NewWebhooks(cfg)
+		go hooks.Start()
+		defer hooks.Close()
+
+		// deleted keys are notified from the replication log
+		replication = append(replication, hooks)
+	}
 
-	log.Fatal(serv.Serve())
+	serv := server.NewServer(cfg, cache, replication, sched)
+
+	expireListeners := []func(key sdk.KeyInfo){serv.NotifyExpired}
+	if wb != nil {
+		expireListeners = append(expireListeners, wb.Expired)
+	}
+	if hooks != nil {
+		expireListeners = append(expireListeners, hooks.Expired)
+	}
+
+	go repl.Start()
+	go sched.Start()
+	go cache.WatchSheduler(sched, expireListeners...)
+
+	if cfg.ActiveExpireEveryMs > 0 {
+		period := time.Duration(cfg.ActiveExpireEveryMs) * time.Millisecond
+		go cache.ActiveExpire(sched, period, int(cfg.ActiveExpireSampleSize), expireListeners...)
+	}
+
+	// an error of any server stops the others the same way as signals
+	errs := make(chan error, 4)
+
+	go func() {
+		errs <- serv.Serve()
+	}()
+	defer serv.Close()
+
+	if cfg.RespBindAddr != "" {
+		respServ := resp.NewRespServer(cfg, serv)
+		defer respServ.Close()
+
+		go func() {
+			errs <- respServ.Serve()
+		}()
+	}
+
+	if cfg.GrpcBindAddr != "" {
+		grpcServ := grpcserver.NewGrpcServer(cfg, serv)
+		defer grpcServ.Close()
+
+		go func() {
+			errs <- grpcServ.Serve()
+		}()
+	}
+
+	if cfg.MemcacheBindAddr != "" {
+		memcacheServ := memcache.NewMemcacheServer(cfg, serv)
+		defer memcacheServ.Close()
+
+		go func() {
+			errs <- memcacheServ.Serve()
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errs:
+		log.Printf("server error %s, shutting down", err.Error())
+		return err
+	case sig := <-stop:
+		log.Printf("received %s, shutting down", sig)
+		return nil
+	}
 }